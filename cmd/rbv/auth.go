@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"radiobuenavia/internal/config"
+	"radiobuenavia/internal/dropbox"
+)
+
+const authUsage = "usage: rbv auth <status|login|revoke> [-config path]"
+
+func runAuth(args []string) {
+	if len(args) == 0 {
+		log.Fatal(authUsage)
+	}
+	fs := flag.NewFlagSet("auth "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "./config.toml", "path to config file")
+	_ = fs.Parse(args[1:])
+
+	switch args[0] {
+	case "status":
+		authStatus(*configPath)
+	case "login":
+		authLogin(*configPath)
+	case "revoke":
+		authRevoke(*configPath)
+	default:
+		log.Fatal(authUsage)
+	}
+}
+
+func authStatus(configPath string) {
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	if cfg.Auth.AppKey == "" || cfg.Auth.RefreshToken == "" {
+		log.Fatal("not authorized; run rbv auth login")
+	}
+	dbx, err := dropbox.NewClient(cfg.Auth.AppKey, cfg.Auth.AppSecret, cfg.Auth.RefreshToken)
+	if err != nil {
+		log.Fatalf("refresh token rejected: %v", err)
+	}
+	acct, err := dbx.GetCurrentAccount()
+	if err != nil {
+		log.Fatalf("dropbox account check failed: %v", err)
+	}
+
+	method := "app secret"
+	if cfg.Auth.AppSecret == "" {
+		method = "PKCE"
+	}
+	scopes := strings.Join(dbx.Scopes(), " ")
+	if scopes == "" {
+		scopes = "(not reported)"
+	}
+	fmt.Printf("account:  %s (%s)\n", acct.Name, acct.Email)
+	fmt.Printf("id:       %s\n", acct.AccountID)
+	fmt.Printf("app key:  %s\n", cfg.Auth.AppKey)
+	fmt.Printf("method:   %s\n", method)
+	fmt.Printf("scopes:   %s\n", scopes)
+}
+
+func authLogin(configPath string) {
+	// Check the file parses before asking for an authorization code.
+	if _, err := config.Read(configPath); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	appKey, appSecret, refreshToken, err := authorize(reader)
+	if err != nil {
+		log.Fatalf("auth code exchange failed: %v", err)
+	}
+	auth := config.AuthConfig{
+		AppKey:       appKey,
+		AppSecret:    appSecret,
+		RefreshToken: refreshToken,
+	}
+	if err := config.SaveAuth(configPath, auth); err != nil {
+		log.Fatalf("failed to write config: %v", err)
+	}
+	log.Printf("Updated [auth] in %s", configPath)
+}
+
+func authRevoke(configPath string) {
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	if cfg.Auth.RefreshToken == "" {
+		log.Fatal("no refresh token configured")
+	}
+	dbx, err := dropbox.NewClient(cfg.Auth.AppKey, cfg.Auth.AppSecret, cfg.Auth.RefreshToken)
+	if err != nil {
+		log.Fatalf("refresh token rejected: %v", err)
+	}
+	if err := dbx.RevokeToken(); err != nil {
+		log.Fatalf("revoke failed: %v", err)
+	}
+	cfg.Auth.RefreshToken = ""
+	if err := config.SaveAuth(configPath, cfg.Auth); err != nil {
+		log.Fatalf("token revoked, but failed to clear it from config: %v", err)
+	}
+	log.Printf("Refresh token revoked and removed from %s. Run rbv auth login to authorize again.", configPath)
+}
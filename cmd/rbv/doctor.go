@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 
 	"radiobuenavia/internal/config"
 	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/storage"
 )
 
 func runDoctor(args []string) {
@@ -24,23 +26,29 @@ func runDoctor(args []string) {
 		log.Fatalf("config error: %v", err)
 	}
 
-	dbx, err := dropbox.NewClient(cfg.Auth.AppKey, cfg.Auth.AppSecret, cfg.Auth.RefreshToken)
+	store, err := storage.New(cfg)
 	if err != nil {
-		log.Fatalf("dropbox client error: %v", err)
+		log.Fatalf("%s storage error: %v", cfg.Storage.Backend, err)
 	}
+	log.Printf("storage backend: %s", cfg.Storage.Backend)
 
-	acct, err := dbx.GetCurrentAccount()
-	if err != nil {
-		log.Printf("dropbox account check failed: %v", err)
-	} else {
-		log.Printf("dropbox account: %s (%s)", acct.Name, acct.Email)
+	if dbx, ok := store.(*dropbox.Client); ok {
+		acct, err := dbx.GetCurrentAccount()
+		if err != nil {
+			log.Printf("dropbox account check failed: %v", err)
+		} else {
+			log.Printf("dropbox account: %s (%s)", acct.Name, acct.Email)
+			if cfg.Storage.TeamSpace {
+				log.Printf("dropbox team space: root namespace %s", acct.RootNamespaceID)
+			}
+		}
 	}
 
-	checkPath(dbx, "preprocess_live", cfg.Paths.PreprocessLive)
-	checkPath(dbx, "preprocess_prerecord", cfg.Paths.PreprocessPrerecord)
+	checkPath(store, "preprocess_live", cfg.Paths.PreprocessLive)
+	checkPath(store, "preprocess_prerecord", cfg.Paths.PreprocessPrerecord)
 	ok := true
-	checkPath(dbx, "postprocess_soundcloud", cfg.Paths.PostprocessSoundcloud)
-	checkPath(dbx, "postprocess_archive", cfg.Paths.PostprocessArchive)
+	checkPath(store, "postprocess_soundcloud", cfg.Paths.PostprocessSoundcloud)
+	checkPath(store, "postprocess_archive", cfg.Paths.PostprocessArchive)
 
 	checkJingles(cfg.Paths.Jingles, cfg.Paths.JinglesDir)
 	if !checkTool("ffmpeg") {
@@ -54,12 +62,12 @@ func runDoctor(args []string) {
 	}
 }
 
-func checkPath(dbx *dropbox.Client, label, path string) {
+func checkPath(store storage.Backend, label, path string) {
 	if strings.TrimSpace(path) == "" {
 		log.Printf("%s: not set", label)
 		return
 	}
-	files, err := dbx.ListFiles(path)
+	files, err := store.ListFilesContext(context.Background(), path)
 	if err != nil {
 		log.Printf("%s: error (%s): %v", label, path, err)
 		return
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"radiobuenavia/internal/config"
 	"radiobuenavia/internal/dropbox"
@@ -24,14 +28,7 @@ func runInit(args []string) {
 	log.Print(art)
 
 	reader := bufio.NewReader(os.Stdin)
-	appKey := prompt(reader, "Dropbox app key")
-	appSecret := prompt(reader, "Dropbox app secret")
-
-	fmt.Println("\nOpen this URL in your browser, authorize the app, and copy the code:")
-	fmt.Printf("https://www.dropbox.com/oauth2/authorize?client_id=%s&token_access_type=offline&response_type=code\n\n", appKey)
-	code := prompt(reader, "Authorization code")
-
-	refreshToken, err := dropbox.ExchangeAuthCode(appKey, appSecret, code)
+	appKey, appSecret, refreshToken, err := authorize(reader)
 	if err != nil {
 		log.Fatalf("auth code exchange failed: %v", err)
 	}
@@ -40,6 +37,7 @@ func runInit(args []string) {
 	preprocessPrerecord := promptDefault(reader, "Preprocess prerecord path", "/automation/preprocessed/prerecord")
 	postprocessSoundcloud := promptDefault(reader, "Postprocess soundcloud path", "/automation/postprocessed")
 	postprocessArchive := promptDefault(reader, "Postprocess archive path", "/automation/archive")
+	processed := promptDefault(reader, "Move finished sources to (optional)", "")
 	jinglesDir := promptDefault(reader, "Jingles folder (optional)", "")
 	jinglesRaw := promptDefault(reader, "Extra jingle paths (comma-separated, optional)", "")
 	jingles := parseList(jinglesRaw)
@@ -55,9 +53,13 @@ func runInit(args []string) {
 			PreprocessPrerecord:   preprocessPrerecord,
 			PostprocessSoundcloud: postprocessSoundcloud,
 			PostprocessArchive:    postprocessArchive,
+			Processed:             processed,
 			Jingles:               jingles,
 			JinglesDir:            jinglesDir,
 		},
+		Storage: config.StorageConfig{
+			Backend: config.BackendDropbox,
+		},
 	}
 
 	if err := ensureDir(filepath.Dir(*configPath)); err != nil {
@@ -77,6 +79,49 @@ func runInit(args []string) {
 	log.Printf("Wrote %s", *configPath)
 }
 
+// authorize asks for the app credentials and returns a refresh token. With no
+// app secret it uses the PKCE browser flow; otherwise the user pastes the
+// authorization code by hand.
+func authorize(reader *bufio.Reader) (string, string, string, error) {
+	appKey := prompt(reader, "Dropbox app key")
+	appSecret := promptDefault(reader, "Dropbox app secret (leave blank for browser sign-in)", "")
+
+	if appSecret == "" {
+		flow := &dropbox.PKCEFlow{
+			AppKey: appKey,
+			OpenURL: func(authURL string) error {
+				fmt.Printf("\nRegister http://%s/callback as a redirect URI for the app, then open this URL and authorize it:\n%s\n\n", dropbox.DefaultRedirectAddr, authURL)
+				if err := openBrowser(authURL); err != nil {
+					log.Printf("could not open a browser: %v", err)
+				}
+				return nil
+			},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		refreshToken, err := flow.Authorize(ctx)
+		return appKey, "", refreshToken, err
+	}
+
+	fmt.Println("\nOpen this URL in your browser, authorize the app, and copy the code:")
+	fmt.Printf("https://www.dropbox.com/oauth2/authorize?client_id=%s&token_access_type=offline&response_type=code\n\n", appKey)
+	code := prompt(reader, "Authorization code")
+
+	refreshToken, err := dropbox.ExchangeAuthCode(appKey, appSecret, code)
+	return appKey, appSecret, refreshToken, err
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		return exec.Command("open", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
 func prompt(reader *bufio.Reader, label string) string {
 	for {
 		fmt.Printf("%s: ", label)
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 
 	"radiobuenavia/internal/app"
@@ -31,6 +33,7 @@ You are watching... Radio Buena Via...
 
 func main() {
 	exitCode := 0
+	app.Version = version
 	args := os.Args[1:]
 	if len(args) > 0 {
 		switch args[0] {
@@ -40,6 +43,15 @@ func main() {
 		case "doctor":
 			runDoctor(args[1:])
 			return
+		case "auth":
+			runAuth(args[1:])
+			return
+		case "watch":
+			runWatch(args[1:])
+			return
+		case "serve-webhook":
+			runServeWebhook(args[1:])
+			return
 		case "version", "--version", "-version":
 			runVersion()
 			return
@@ -49,9 +61,29 @@ func main() {
 	fs := flag.NewFlagSet("rbv", flag.ExitOnError)
 	configPath := fs.String("config", "./config.toml", "path to config file")
 	pause := fs.Bool("pause", defaultPause(), "pause before exit")
+	yes := fs.Bool("yes", false, "process without asking for confirmation")
+	dryRun := fs.Bool("dry-run", false, "print what would be processed and exit")
+	format := fs.String("format", "text", "dry-run output format: text or json")
+	continueOnError := fs.Bool("continue-on-error", false, "keep processing the other files when one fails")
 	_ = fs.Parse(args)
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %q (want text or json)", *format)
+	}
+	if flagSet(fs, "format") && !*dryRun {
+		log.Fatal("-format only applies to -dry-run")
+	}
+	// Unattended runs must not wait for Enter unless asked to.
+	if (*yes || *dryRun) && !flagSet(fs, "pause") {
+		*pause = false
+	}
 
-	if err := runMain(*configPath); err != nil {
+	var err error
+	if *dryRun {
+		err = runDryRun(*configPath, *format)
+	} else {
+		err = runMain(*configPath, !*yes, *continueOnError)
+	}
+	if err != nil {
 		log.Print(err)
 		exitCode = 1
 	}
@@ -61,7 +93,7 @@ func main() {
 	}
 }
 
-func runMain(configPath string) error {
+func runMain(configPath string, confirm, continueOnError bool) error {
 	log.Print("This software is distributed under the GNU GENERAL PUBLIC LICENSE agreement.")
 	log.Print("This software comes with absolutely no warranty or liability.")
 	log.Print("More information can be found in the LICENSE file.")
@@ -71,14 +103,72 @@ func runMain(configPath string) error {
 	if err != nil {
 		return fmt.Errorf("config error: %w", err)
 	}
+	if continueOnError {
+		cfg.Processing.ContinueOnError = true
+	}
 
+	ctx, stop := interruptContext()
+	defer stop()
 	app := app.New(cfg)
-	if err := app.Run(); err != nil {
+	report, err := app.Run(ctx, confirm)
+	if err != nil {
+		return fmt.Errorf("run failed: %w", err)
+	}
+	if err := report.Err(); err != nil {
 		return fmt.Errorf("run failed: %w", err)
 	}
 	return nil
 }
 
+// runDryRun prints the plan to stdout; everything else goes to the log so
+// the JSON can be piped.
+func runDryRun(configPath, format string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	plan, err := app.New(cfg).Plan(ctx)
+	if err != nil {
+		return fmt.Errorf("dry run failed: %w", err)
+	}
+	if format == "json" {
+		return plan.WriteJSON(os.Stdout)
+	}
+	return plan.WriteText(os.Stdout)
+}
+
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// interruptContext is cancelled by the first Ctrl-C, which stops in-flight
+// transfers and retries instead of killing the process mid-write; partial
+// downloads are resumed on the next run. A second Ctrl-C exits immediately,
+// for steps that cannot be cancelled such as the prompt or Audacity.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			log.Print("Interrupted, stopping... (press Ctrl-C again to quit now)")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
 func defaultPause() bool {
 	return runtime.GOOS == "windows"
 }
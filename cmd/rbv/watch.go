@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"radiobuenavia/internal/app"
+	"radiobuenavia/internal/config"
+)
+
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	configPath := fs.String("config", "./config.toml", "path to config file")
+	_ = fs.Parse(args)
+
+	log.Print("This software is distributed under the GNU GENERAL PUBLIC LICENSE agreement.")
+	log.Print("This software comes with absolutely no warranty or liability.")
+	log.Print("More information can be found in the LICENSE file.")
+	log.Print(art)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	err = app.New(cfg).Watch(ctx)
+	if errors.Is(err, context.Canceled) {
+		log.Print("Stopped watching.")
+		return
+	}
+	if err != nil {
+		log.Fatalf("watch failed: %v", err)
+	}
+}
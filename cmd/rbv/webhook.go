@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"radiobuenavia/internal/app"
+	"radiobuenavia/internal/config"
+)
+
+func runServeWebhook(args []string) {
+	fs := flag.NewFlagSet("serve-webhook", flag.ExitOnError)
+	configPath := fs.String("config", "./config.toml", "path to config file")
+	listen := fs.String("listen", ":8080", "address to serve Dropbox notifications on")
+	_ = fs.Parse(args)
+
+	log.Print("This software is distributed under the GNU GENERAL PUBLIC LICENSE agreement.")
+	log.Print("This software comes with absolutely no warranty or liability.")
+	log.Print("More information can be found in the LICENSE file.")
+	log.Print(art)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	err = app.New(cfg).ServeWebhook(ctx, *listen)
+	if errors.Is(err, context.Canceled) {
+		log.Print("Stopped serving webhooks.")
+		return
+	}
+	if err != nil {
+		log.Fatalf("serve-webhook failed: %v", err)
+	}
+}
@@ -2,223 +2,665 @@ package app
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"radiobuenavia/internal/audacity"
-	"radiobuenavia/internal/audio"
 	"radiobuenavia/internal/config"
 	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/storage"
 )
 
+// Version is recorded with the processed state of each source. main sets
+// it to the build version.
+var Version = "dev"
+
 type App struct {
 	cfg config.Config
 }
 
-type downloadResult struct {
-	file       dropbox.FileMetadata
-	name       string
-	importPath string
-	exportPath string
-	err        error
+// job is one source on its way through a pass. path is its current file
+// and steps the number of stages already run on it; uploaded is set when a
+// previous run already uploaded it.
+type job struct {
+	file     dropbox.FileMetadata
+	name     string
+	path     string
+	scratch  string
+	steps    int
+	uploaded *dropbox.FileMetadata
+	err      error
 }
 
 func New(cfg config.Config) *App {
 	return &App{cfg: cfg}
 }
 
-func (a *App) Run() error {
+// Run processes everything pending once and reports what it published.
+// With confirm set it asks before processing.
+func (a *App) Run(ctx context.Context, confirm bool) (Report, error) {
+	session, err := a.connect()
+	if err != nil {
+		return Report{}, err
+	}
+	defer session.close()
+	return a.processPending(ctx, session, confirm)
+}
+
+// session holds the connections one run or watch keeps open.
+type session struct {
+	store storage.Backend
+	// live and prerecord are the stages of each pass.
+	live      []Stage
+	prerecord []Stage
+	close     func()
+}
+
+func (s *session) stages(live bool) []Stage {
+	if live {
+		return s.live
+	}
+	return s.prerecord
+}
+
+func (a *App) connect() (*session, error) {
 	log.Print("Starting up...")
 	jingles, err := resolveJingles(a.cfg.Paths.Jingles, a.cfg.Paths.JinglesDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Print("Connecting to Audacity...")
-	pipe, err := audacity.NewPipeClient()
-	if err != nil {
-		return err
+	var pipe *audacity.PipeClient
+	if a.usesAudacity() {
+		log.Print("Connecting to Audacity...")
+		pipe, err = audacity.NewPipeClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+	closePipe := func() {
+		if pipe != nil {
+			_ = pipe.Close()
+		}
 	}
-	defer func() {
-		_ = pipe.Close()
-	}()
 
-	log.Print("Connecting to Dropbox...")
-	dbx, err := dropbox.NewClient(a.cfg.Auth.AppKey, a.cfg.Auth.AppSecret, a.cfg.Auth.RefreshToken)
+	log.Printf("Connecting to %s storage...", a.cfg.Storage.Backend)
+	store, err := storage.New(a.cfg)
 	if err != nil {
-		return err
+		closePipe()
+		return nil, err
 	}
+	return &session{
+		store:     store,
+		live:      buildStages(a.passStages(true), pipe, jingles),
+		prerecord: buildStages(a.passStages(false), pipe, jingles),
+		close:     closePipe,
+	}, nil
+}
 
+// processPending lists both preprocess folders and runs the live and
+// prerecord passes, asking first when confirm is set. The summary of what
+// was published is printed at the end.
+func (a *App) processPending(ctx context.Context, s *session, confirm bool) (Report, error) {
 	log.Print("Listing preprocess folders...")
-	liveFiles, err := a.listPass(dbx, "live", a.cfg.Paths.PreprocessLive)
-	if err != nil {
-		return err
-	}
-	prerecordFiles, err := a.listPass(dbx, "prerecord", a.cfg.Paths.PreprocessPrerecord)
+	pending, err := a.listPending(ctx, s.store)
 	if err != nil {
-		return err
+		return Report{}, err
 	}
+	liveFiles := passFiles(a.passPath(true), pending)
+	printPass("live", a.passPath(true), liveFiles)
+	prerecordFiles := passFiles(a.passPath(false), pending)
+	printPass("prerecord", a.passPath(false), prerecordFiles)
 	if len(liveFiles) == 0 && len(prerecordFiles) == 0 {
-		return nil
+		return Report{}, nil
 	}
-	if !promptConfirm("\nProceed? (Y/n)") {
+	if confirm && !promptConfirm("\nProceed? (Y/n)") {
 		log.Print("Goodbye!")
-		return nil
+		return Report{}, nil
 	}
-	if err := a.runPass(dbx, pipe, a.cfg.Paths.PreprocessLive, jingles, true, liveFiles); err != nil {
-		return err
+	j, err := a.openJournal()
+	if err != nil {
+		return Report{}, err
 	}
-	if err := a.runPass(dbx, pipe, a.cfg.Paths.PreprocessPrerecord, jingles, false, prerecordFiles); err != nil {
-		return err
+	var report Report
+	defer func() {
+		report.print(os.Stdout)
+	}()
+	live, err := a.runPass(ctx, s, j, true, liveFiles)
+	report.add(live)
+	if err != nil {
+		return report, err
 	}
-	return nil
+	prerecord, err := a.runPass(ctx, s, j, false, prerecordFiles)
+	report.add(prerecord)
+	return report, err
 }
 
-func (a *App) listPass(dbx *dropbox.Client, label, preprocessPath string) ([]dropbox.FileMetadata, error) {
-	if strings.TrimSpace(preprocessPath) == "" {
-		return nil, nil
+// listPending lists both preprocess folders in one call so duplicate
+// uploads and colliding names are resolved across them.
+func (a *App) listPending(ctx context.Context, store storage.Backend) ([]dropbox.FileMetadata, error) {
+	paths := a.preprocessPaths()
+	list := store.ListFilesToProcessContext
+	if a.cfg.Paths.Processed != "" {
+		list = store.ListPendingFilesContext
+	}
+	pending, err := list(ctx, paths, a.cfg.Paths.PostprocessArchive)
+	if errors.Is(err, dropbox.ErrNotFound) {
+		return nil, fmt.Errorf("config error: a preprocess path points at a missing folder: %w", err)
 	}
-	preproc, err := dbx.ListFilesToProcess(preprocessPath, a.cfg.Paths.PostprocessArchive)
 	if err != nil {
 		return nil, err
 	}
-	preproc = filterMp3Files(preproc)
-	if len(preproc) == 0 {
+	return filterMp3Files(pending), nil
+}
+
+// preprocessPaths returns the configured preprocess folders, naming a
+// folder shared by both passes once.
+func (a *App) preprocessPaths() []string {
+	var paths []string
+	for _, preprocessPath := range []string{a.cfg.Paths.PreprocessLive, a.cfg.Paths.PreprocessPrerecord} {
+		if strings.TrimSpace(preprocessPath) == "" {
+			continue
+		}
+		duplicate := false
+		for _, seen := range paths {
+			if samePath(seen, preprocessPath) {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			paths = append(paths, preprocessPath)
+		}
+	}
+	return paths
+}
+
+// passPath returns the folder the live or prerecord pass takes its files
+// from. When both passes share a folder the live pass takes its files, so
+// none is processed twice.
+func (a *App) passPath(live bool) string {
+	if live {
+		return a.cfg.Paths.PreprocessLive
+	}
+	if strings.TrimSpace(a.cfg.Paths.PreprocessLive) != "" && samePath(a.cfg.Paths.PreprocessLive, a.cfg.Paths.PreprocessPrerecord) {
+		return ""
+	}
+	return a.cfg.Paths.PreprocessPrerecord
+}
+
+func samePath(a, b string) bool {
+	return strings.EqualFold(path.Clean(a), path.Clean(b))
+}
+
+// passFiles picks the pending files in preprocessPath.
+func passFiles(preprocessPath string, pending []dropbox.FileMetadata) []dropbox.FileMetadata {
+	if strings.TrimSpace(preprocessPath) == "" {
+		return nil
+	}
+	dir := strings.ToLower(path.Clean(preprocessPath))
+	var files []dropbox.FileMetadata
+	for _, file := range pending {
+		if strings.ToLower(path.Dir(file.PathLower)) == dir {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// printPass lists what the files of a pass will be published as.
+func printPass(label, preprocessPath string, files []dropbox.FileMetadata) {
+	if strings.TrimSpace(preprocessPath) == "" {
+		return
+	}
+	if len(files) == 0 {
 		log.Printf("No new files to process in %s.", preprocessPath)
+		return
+	}
+	fmt.Printf("\nFiles to process (%s) (%d):\n\n", label, len(files))
+	for _, file := range files {
+		fmt.Printf("%s -> %s\n", file.Name, withMp3Ext(dropbox.RenameFile(file)))
+	}
+}
+
+// openJournal opens the journal in state.dir. Without a state dir nothing
+// is journaled and every run starts from scratch.
+func (a *App) openJournal() (*journal, error) {
+	if a.cfg.State.Dir == "" {
 		return nil, nil
 	}
-	fmt.Printf("\nFiles to process (%s) (%d):\n\n", label, len(preproc))
-	for _, file := range preproc {
-		fmt.Printf("%s -> %s\n", file.Name, withMp3Ext(dbx.RenameFile(file)))
+	j, err := openJournal(filepath.Join(a.cfg.State.Dir, "journal.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
 	}
-	return preproc, nil
+	return j, nil
+}
+
+// note records a stage in the journal. A journal that cannot be written
+// only costs the ability to resume, so the run goes on.
+func note(err error) {
+	if err != nil {
+		log.Printf("Could not update the journal: %v", err)
+	}
+}
+
+// pass is the state the workers of one runPass share. A file that fails is
+// listed in the report; unless processing.continue_on_error is set the
+// first failure also stops the pass.
+type pass struct {
+	app     *App
+	journal *journal
+	stop    context.CancelFunc
+	// ffmpeg holds a slot for every non-serial stage run in progress.
+	ffmpeg chan struct{}
+	mu     sync.Mutex
+	report Report
+	err    error
+}
+
+// fail lists a failed file in the report.
+func (p *pass) fail(file dropbox.FileMetadata, stage string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Failed = append(p.report.Failed, Failure{Source: file.Name, Stage: stage, Err: err})
+	if p.app.cfg.Processing.ContinueOnError {
+		log.Printf("%s %q failed, continuing with the other files: %v", stage, file.Name, err)
+		return
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("%s %q failed: %w", stage, file.Name, err)
+		p.stop()
+	}
+}
+
+// stopped reports whether a failure stopped the pass.
+func (p *pass) stopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err != nil
+}
+
+func (p *pass) publish(published Published) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Published = append(p.report.Published, published)
 }
 
-func (a *App) runPass(dbx *dropbox.Client, pipe *audacity.PipeClient, preprocessPath string, jingles []string, live bool, preproc []dropbox.FileMetadata) error {
+// runPass downloads the files of one pass, runs them through the pass's
+// stages and publishes the results. Every stage has its own workers,
+// connected by queues that hold the whole pass, so a slow stage never holds
+// up the one before it. A serial stage such as Audacity has one worker; the
+// other stages together run at most processing.ffmpeg_workers files at a
+// time. When a failure stops the pass, files
+// already uploaded are still archived and the journal keeps the rest for
+// the next run.
+func (a *App) runPass(ctx context.Context, s *session, j *journal, live bool, preproc []dropbox.FileMetadata) (Report, error) {
 	if len(preproc) == 0 {
-		return nil
+		return Report{}, nil
 	}
 
 	tmpDir := filepath.Join(os.TempDir(), "rbv")
 	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-		return err
+		return Report{}, err
 	}
 
-	uploadCh, uploadDone := a.startUploadWorker(dbx)
-	results := a.startDownloadWorker(dbx, preproc, tmpDir)
+	passCtx, stopPass := context.WithCancel(ctx)
+	defer stopPass()
+	p := &pass{app: a, journal: j, stop: stopPass, ffmpeg: make(chan struct{}, a.ffmpegWorkers())}
+	stages := s.stages(live)
+	pipeline := a.pipelineKey(live)
 
-	for i := 0; i < len(preproc); i++ {
+	results := a.startDownloadWorker(passCtx, s.store, j, pipeline, len(stages), preproc, tmpDir)
+	queue := make(chan job, len(preproc))
+	var next <-chan job = queue
+	for i, stage := range stages {
+		next = p.startStage(ctx, stage, i, pipeline, next, len(preproc))
+	}
+	uploadDone := p.startUploadWorker(ctx, s.store, next)
+
+	err := p.feed(passCtx, results, len(preproc), queue)
+	close(queue)
+	<-uploadDone
+	if p.err != nil {
+		err = p.err
+	}
+	return p.report, err
+}
+
+// feed hands the downloaded files on to the first stage.
+func (p *pass) feed(ctx context.Context, results <-chan job, count int, queue chan<- job) error {
+	for i := 0; i < count; i++ {
 		result, ok := <-results
 		if !ok {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			return fmt.Errorf("download worker stopped unexpectedly")
 		}
 		if result.err != nil {
-			return result.err
-		}
-
-		fmt.Printf("\n\tProcessing:\n\t\t%s\n\n", result.name)
-
-		if err := processFile(pipe, result, live, jingles); err != nil {
-			return err
+			p.fail(result.file, "download", result.err)
+			continue
 		}
+		queue <- result
+	}
+	return nil
+}
 
-		uploadCh <- uploadTask{
-			name: result.name,
-			path: result.exportPath,
-		}
+// startStage starts the workers of the index-th stage of a pass. They skip
+// files a previous run already took past the stage, and the returned queue
+// is closed once in is and every worker is done. Workers of a non-serial
+// stage take a slot of p.ffmpeg for each run, so the pool is shared by all
+// of them.
+func (p *pass) startStage(ctx context.Context, stage Stage, index int, pipeline string, in <-chan job, count int) <-chan job {
+	out := make(chan job, count)
+	workers := 1
+	if !stage.Serial() {
+		workers = p.app.ffmpegWorkers()
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next := range in {
+				if p.stopped() || ctx.Err() != nil {
+					continue
+				}
+				if next.steps > index {
+					out <- next
+					continue
+				}
+				if !stage.Serial() {
+					p.ffmpeg <- struct{}{}
+				}
+				path, err := stage.Run(ctx, Work{
+					Name:    next.name,
+					Path:    next.path,
+					Scratch: fmt.Sprintf("%s-%d.mp3", next.scratch, index+1),
+				})
+				if !stage.Serial() {
+					<-p.ffmpeg
+				}
+				if err != nil {
+					p.fail(next.file, stage.Name(), err)
+					continue
+				}
+				next.path, next.steps = path, index+1
+				note(p.journal.recordStep(next.file, pipeline, next.steps, path))
+				out <- next
+			}
+		}()
 	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
 
-	close(uploadCh)
-	if err := <-uploadDone; err != nil {
-		return err
+func (a *App) ffmpegWorkers() int {
+	if a.cfg.Processing.FFmpegWorkers > 0 {
+		return a.cfg.Processing.FFmpegWorkers
 	}
-	return nil
+	return runtime.NumCPU()
 }
 
-func (a *App) startDownloadWorker(dbx *dropbox.Client, preproc []dropbox.FileMetadata, tmpDir string) <-chan downloadResult {
-	results := make(chan downloadResult, 1)
+func (a *App) startDownloadWorker(ctx context.Context, store storage.Backend, j *journal, pipeline string, stages int, preproc []dropbox.FileMetadata, tmpDir string) <-chan job {
+	results := make(chan job, 1)
+	send := func(result job) bool {
+		select {
+		case results <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	go func() {
 		defer close(results)
 		for _, file := range preproc {
-			name := dbx.RenameFile(file)
+			name := dropbox.RenameFile(file)
 			exportName := withMp3Ext(name)
 			cleanName := strings.ReplaceAll(name, " ", "-")
 			cleanExportName := strings.ReplaceAll(exportName, " ", "-")
-			importPath := filepath.Join(tmpDir, "im-"+cleanName)
-			exportPath := filepath.Join(tmpDir, "ex-"+cleanExportName)
+			next := job{
+				file:    file,
+				name:    exportName,
+				path:    filepath.Join(tmpDir, "im-"+cleanName),
+				scratch: filepath.Join(tmpDir, "ex-"+strings.TrimSuffix(cleanExportName, filepath.Ext(cleanExportName))),
+			}
 
-			log.Printf("Downloading %s to %s", file.Name, importPath)
-			if err := retry(fmt.Sprintf("download %q", file.Name), 3, 2*time.Second, func() error {
-				return dbx.DownloadFile(importPath, file.PathLower)
+			entry, resumed := j.resume(file, pipeline)
+			if resumed == progressUploaded {
+				// Someone may have moved or deleted the upload since.
+				err := retry(ctx, fmt.Sprintf("check upload %q", entry.Uploaded), 3, 2*time.Second, func() error {
+					_, err := store.GetMetadataContext(ctx, entry.UploadedPath)
+					return err
+				})
+				if errors.Is(err, dropbox.ErrNotFound) {
+					log.Printf("Upload %q of %s is gone; processing it again", entry.Uploaded, file.Name)
+					entry, resumed = j.resumeBeforeUpload(file, pipeline)
+				} else if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					next.err = fmt.Errorf("check upload %q: %w", entry.Uploaded, err)
+					if !send(next) || !a.cfg.Processing.ContinueOnError {
+						return
+					}
+					continue
+				}
+			}
+			switch {
+			case resumed == progressUploaded:
+				next.steps = stages
+				next.uploaded = &dropbox.FileMetadata{Name: entry.Uploaded, PathLower: entry.UploadedPath}
+			case resumed == progressProcessed:
+				next.path, next.steps = entry.Export, entry.Steps
+			case resumed.atLeast(progressDownloaded):
+				next.path = entry.Import
+			}
+			if resumed.atLeast(progressDownloaded) {
+				log.Printf("Resuming %s after %d of %d stage(s)", file.Name, next.steps, stages)
+				if !send(next) {
+					return
+				}
+				continue
+			}
+
+			log.Printf("Downloading %s to %s", file.Name, next.path)
+			if err := retry(ctx, fmt.Sprintf("download %q", file.Name), 3, 2*time.Second, func() error {
+				return store.DownloadFileContext(ctx, next.path, file)
 			}); err != nil {
-				results <- downloadResult{err: fmt.Errorf("download %q failed: %w", file.Name, err)}
-				return
+				// A download cut short by a stopped pass is not a failure of
+				// its own.
+				if ctx.Err() != nil {
+					return
+				}
+				next.err = err
+				if !send(next) || !a.cfg.Processing.ContinueOnError {
+					return
+				}
+				continue
 			}
-			results <- downloadResult{
-				file:       file,
-				name:       exportName,
-				importPath: importPath,
-				exportPath: exportPath,
+			note(j.recordDownload(file, next.path))
+			if !send(next) {
+				return
 			}
 		}
 	}()
 	return results
 }
 
-func processFile(pipe *audacity.PipeClient, result downloadResult, live bool, jingles []string) error {
-	log.Print("Processing...")
-	if err := pipe.Process(result.importPath, result.exportPath, live); err != nil {
+// startUploadWorker uploads what the last stage produces and archives the
+// uploads once the queue is closed. done is closed when it has finished.
+func (p *pass) startUploadWorker(ctx context.Context, store storage.Backend, queue <-chan job) <-chan struct{} {
+	a := p.app
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var uploads []upload
+		for next := range queue {
+			if p.stopped() {
+				continue
+			}
+			var uploaded dropbox.FileMetadata
+			if next.uploaded != nil {
+				log.Printf("Already uploaded %s", next.uploaded.Name)
+				uploaded = *next.uploaded
+			} else {
+				log.Printf("Uploading... %s", next.name)
+				if err := retry(ctx, fmt.Sprintf("upload %q", next.name), 3, 2*time.Second, func() error {
+					var err error
+					uploaded, err = store.UploadFileSoundcloudContext(ctx, next.path, next.name, a.cfg.Paths.PostprocessSoundcloud, dropbox.WritePolicy(a.cfg.Conflicts.Soundcloud))
+					return err
+				}); err != nil {
+					p.fail(next.file, "upload", err)
+					continue
+				}
+				note(p.journal.record(next.file, progressUploaded, func(entry *journalEntry) {
+					entry.Uploaded, entry.UploadedPath = uploaded.Name, uploaded.PathLower
+				}))
+			}
+			if uploaded.Name != next.name {
+				log.Printf("Uploaded %q as %q", next.name, uploaded.Name)
+			}
+			p.publish(Published{
+				Source:     next.file.Name,
+				Name:       uploaded.Name,
+				Path:       uploaded.PathLower,
+				SharedLink: a.sharedLink(ctx, store, uploaded),
+			})
+			uploads = append(uploads, upload{source: next.file, name: uploaded.Name})
+		}
+		p.archive(ctx, store, uploads)
+	}()
+	return done
+}
+
+// upload is a file in postprocess_soundcloud waiting to be archived.
+type upload struct {
+	source dropbox.FileMetadata
+	name   string
+}
+
+// archive copies every uploaded file into the archive folder as one batch
+// and then moves the sources of the successful copies to the processed
+// folder. Failed copies are reported like any other failure.
+func (p *pass) archive(ctx context.Context, store storage.Backend, uploads []upload) {
+	a := p.app
+	if len(uploads) == 0 {
+		return
+	}
+	names := make([]string, 0, len(uploads))
+	for _, uploaded := range uploads {
+		names = append(names, uploaded.name)
+	}
+	policy := dropbox.WritePolicy(a.cfg.Conflicts.Archive)
+	log.Printf("Copying %d file(s) to archive...", len(names))
+	var results []dropbox.CopyResult
+	if err := retry(ctx, "archive copy", 3, 2*time.Second, func() error {
+		var err error
+		results, err = store.CopyBatchToArchiveContext(ctx, names, a.cfg.Paths.PostprocessSoundcloud, a.cfg.Paths.PostprocessArchive, policy)
 		return err
+	}); err != nil {
+		for _, uploaded := range uploads {
+			p.fail(uploaded.source, "archive", err)
+		}
+		return
 	}
-	log.Print("Done!")
 
-	artist := audio.GetArtist(result.name)
-	log.Print("Setting artist name and potentially changing bitrate.")
-	if err := audio.ProcessMetadataAndBitrate(result.exportPath, artist, jingles); err != nil {
+	for i, result := range results {
+		archived, err := result.Metadata, result.Err
+		if err != nil && isRetryableError(err) {
+			err = retry(ctx, fmt.Sprintf("archive copy %q", result.Name), 3, 2*time.Second, func() error {
+				var err error
+				archived, err = store.CopyToArchiveContext(ctx, result.Name, a.cfg.Paths.PostprocessSoundcloud, a.cfg.Paths.PostprocessArchive, policy)
+				return err
+			})
+		}
+		if err != nil {
+			p.fail(uploads[i].source, "archive", err)
+			continue
+		}
+		log.Printf("Archived %q", result.Name)
+		note(p.journal.record(uploads[i].source, progressArchived, nil))
+		a.markProcessed(ctx, store, uploads[i].source, archived)
+		if a.cfg.Paths.Processed != "" {
+			a.moveToProcessed(ctx, store, uploads[i].source)
+		}
+	}
+}
+
+// sharedLink is best effort: a missing link only leaves the summary without
+// one.
+func (a *App) sharedLink(ctx context.Context, store storage.Backend, file dropbox.FileMetadata) string {
+	if !a.cfg.Sharing.Enabled {
+		return ""
+	}
+	sharer, ok := store.(storage.Sharer)
+	if !ok {
+		return ""
+	}
+	settings := dropbox.SharedLinkSettings{Password: a.cfg.Sharing.Password}
+	if expires, err := time.ParseDuration(a.cfg.Sharing.Expires); err == nil {
+		settings.Expires = time.Now().Add(expires)
+	}
+	var link string
+	if err := retry(ctx, fmt.Sprintf("share %q", file.Name), 3, 2*time.Second, func() error {
+		var err error
+		link, err = sharer.CreateSharedLinkContext(ctx, file.PathLower, settings)
 		return err
+	}); err != nil {
+		log.Printf("Could not create a shared link for %q: %v", file.Name, err)
+		return ""
 	}
-	return nil
+	return link
 }
 
-type uploadTask struct {
-	name string
-	path string
+// markProcessed is best effort: an unmarked source is still recognised by
+// its archive name.
+func (a *App) markProcessed(ctx context.Context, store storage.Backend, source, archived dropbox.FileMetadata) {
+	if !a.cfg.State.Properties {
+		return
+	}
+	marker, ok := store.(storage.Marker)
+	if !ok {
+		return
+	}
+	state := dropbox.ProcessedState{
+		Status:      dropbox.StatusProcessed,
+		OutputPath:  archived.PathLower,
+		ProcessedAt: time.Now(),
+		Version:     Version,
+	}
+	if err := retry(ctx, fmt.Sprintf("mark %q", source.Name), 3, 2*time.Second, func() error {
+		return marker.MarkProcessedContext(ctx, source.PathLower, state)
+	}); err != nil {
+		log.Printf("Could not record %q as processed: %v", source.Name, err)
+	}
 }
 
-func (a *App) startUploadWorker(dbx *dropbox.Client) (chan<- uploadTask, <-chan error) {
-	tasks := make(chan uploadTask, 1)
-	done := make(chan error, 1)
-	go func() {
-		var firstErr error
-		for task := range tasks {
-			if firstErr != nil {
-				continue
-			}
-			log.Printf("Uploading... %s", task.name)
-			if err := retry(fmt.Sprintf("upload %q", task.name), 3, 2*time.Second, func() error {
-				return dbx.UploadFileSoundcloud(task.path, task.name, a.cfg.Paths.PostprocessSoundcloud)
-			}); err != nil {
-				firstErr = fmt.Errorf("upload %q failed: %w", task.name, err)
-				continue
-			}
-			log.Printf("Copying to archive... %s", task.name)
-			if err := retry(fmt.Sprintf("archive copy %q", task.name), 3, 2*time.Second, func() error {
-				return dbx.CopyToArchive(task.name, a.cfg.Paths.PostprocessSoundcloud, a.cfg.Paths.PostprocessArchive)
-			}); err != nil {
-				firstErr = fmt.Errorf("archive copy %q failed: %w", task.name, err)
-				continue
-			}
-			log.Printf("Uploaded %q", task.name)
-		}
-		done <- firstErr
-	}()
-	return tasks, done
+// moveToProcessed is best effort: a source left behind is skipped next time
+// because its archive copy already exists.
+func (a *App) moveToProcessed(ctx context.Context, store storage.Backend, source dropbox.FileMetadata) {
+	if err := retry(ctx, fmt.Sprintf("move %q", source.Name), 3, 2*time.Second, func() error {
+		_, err := store.MoveToProcessedContext(ctx, source, a.cfg.Paths.Processed)
+		return err
+	}); err != nil {
+		log.Printf("Could not move %q to %s: %v", source.Name, a.cfg.Paths.Processed, err)
+		return
+	}
+	log.Printf("Moved %q to %s", source.Name, a.cfg.Paths.Processed)
 }
 
 func validateJingles(jingles []string) error {
@@ -301,13 +743,19 @@ func promptConfirm(prompt string) bool {
 	return line == "" || strings.EqualFold(line, "y")
 }
 
-func retry(operation string, attempts int, delay time.Duration, fn func() error) error {
+// retry runs fn up to attempts times while it fails with a retryable error.
+// It gives up as soon as ctx is done, including while waiting between
+// attempts.
+func retry(ctx context.Context, operation string, attempts int, delay time.Duration, fn func() error) error {
 	var err error
 	for i := 0; i < attempts; i++ {
 		err = fn()
 		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return cancelled(ctx, err)
+		}
 		if i == attempts-1 {
 			break
 		}
@@ -316,16 +764,36 @@ func retry(operation string, attempts int, delay time.Duration, fn func() error)
 		}
 		wait := retryDelay(err, delay, i)
 		log.Printf("Retrying %s after error (%d/%d): %v (next attempt in %s)", operation, i+1, attempts, err, wait.Round(time.Millisecond))
-		time.Sleep(wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return cancelled(ctx, err)
+		case <-timer.C:
+		}
 	}
 	return err
 }
 
+// cancelled reports that ctx ended the retries, keeping the last error.
+func cancelled(ctx context.Context, err error) error {
+	if errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+}
+
 func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
 	var apiErr *dropbox.APIError
 	if errors.As(err, &apiErr) {
 		return apiErr.Retryable()
 	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, dropbox.ErrContentHashMismatch) {
+		return true
+	}
 	var netErr net.Error
 	return errors.As(err, &netErr)
 }
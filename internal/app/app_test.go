@@ -0,0 +1,454 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"radiobuenavia/internal/config"
+	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/dropbox/dropboxtest"
+	"radiobuenavia/internal/storage"
+)
+
+// testStamp is when the first of newTestApp's files was modified; each
+// further file is an hour later, so listings keep their order.
+var testStamp = time.Date(2024, 3, 9, 20, 0, 0, 0, time.UTC)
+
+type testFile struct {
+	path    string
+	content string
+}
+
+// newTestApp serves files from a fake Dropbox with the post and archive
+// folders in place and returns an App for the live folder with its journal
+// in a temporary state dir. configure adjusts the config when not nil.
+func newTestApp(t *testing.T, configure func(*config.Config), files ...testFile) (*App, *dropboxtest.Server, storage.Backend) {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	srv := dropboxtest.NewServer()
+	t.Cleanup(srv.Close)
+	for i, file := range files {
+		srv.PutFile(file.path, []byte(file.content), testStamp.Add(time.Duration(i)*time.Hour))
+	}
+	srv.Mkdir("/automation/post")
+	srv.Mkdir("/automation/archive")
+
+	store, err := dropbox.NewClient("key", "secret", dropboxtest.RefreshToken, dropbox.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{
+		Paths: config.PathsConfig{
+			PreprocessLive:        "/automation/live",
+			PostprocessSoundcloud: "/automation/post",
+			PostprocessArchive:    "/automation/archive",
+		},
+		Conflicts: config.ConflictsConfig{
+			Soundcloud: config.PolicySkipIfIdentical,
+			Archive:    config.PolicySkipIfIdentical,
+		},
+		State: config.StateConfig{Dir: t.TempDir()},
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	return New(cfg), srv, store
+}
+
+func TestProcessPendingEndToEnd(t *testing.T) {
+	a, srv, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Paths.Processed = "/automation/done"
+	}, testFile{"/automation/live/Host - Show.mp3", "raw audio"})
+	s := &session{
+		store: store,
+		live:  []Stage{rewriteStage("audacity", true, "processed ")},
+		close: func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := dropbox.RenameFile(dropbox.FileMetadata{Name: "Host - Show.mp3", ClientModified: testStamp})
+	if len(report.Published) != 1 || report.Published[0].Name != name {
+		t.Fatalf("expected %q to be published, got %+v", name, report.Published)
+	}
+	for _, path := range []string{"/automation/post/" + name, "/automation/archive/" + name} {
+		got, ok := srv.File(path)
+		if !ok || string(got) != "processed raw audio" {
+			t.Fatalf("expected processed audio at %s, got %q", path, got)
+		}
+	}
+	if _, ok := srv.File("/automation/done/Host - Show.mp3"); !ok {
+		t.Fatal("expected the source to be moved to the processed folder")
+	}
+	if srv.Calls("/2/files/copy_batch_v2") != 1 {
+		t.Fatal("expected the archive copy to go through copy_batch_v2")
+	}
+
+	report, err = a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 0 {
+		t.Fatalf("expected nothing left to process, got %+v", report.Published)
+	}
+}
+
+func TestProcessPendingKeepsNamesHeldByTheArchive(t *testing.T) {
+	a, srv, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Paths.PreprocessPrerecord = "/automation/live/"
+		cfg.Paths.Processed = "/automation/done"
+	}, testFile{"/automation/live/mix.mp3", "second mix"})
+	// An earlier mix of the same day was published and moved out already.
+	name := dropbox.RenameFile(dropbox.FileMetadata{Name: "mix.mp3", ClientModified: testStamp})
+	srv.PutFile("/automation/archive/"+name, []byte("first mix"), testStamp.Add(-time.Hour))
+	s := &session{
+		store:     store,
+		live:      []Stage{rewriteStage("audacity", true, "live ")},
+		prerecord: []Stage{rewriteStage("audacity", true, "prerecord ")},
+		close:     func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffixed := strings.TrimSuffix(name, ".mp3") + " (2).mp3"
+	if len(report.Published) != 1 || report.Published[0].Name != suffixed {
+		t.Fatalf("expected the second mix to be published once as %q, got %+v", suffixed, report.Published)
+	}
+	if got, _ := srv.File("/automation/archive/" + name); string(got) != "first mix" {
+		t.Fatalf("expected the first mix to stay in the archive, got %q", got)
+	}
+	if got, _ := srv.File("/automation/archive/" + suffixed); string(got) != "live second mix" {
+		t.Fatalf("expected the second mix to be archived, got %q", got)
+	}
+}
+
+func TestProcessPendingResumesFromJournal(t *testing.T) {
+	a, srv, store := newTestApp(t, nil, testFile{"/automation/live/Host - Show.mp3", "raw audio"})
+
+	// A previous run got as far as tagging before it was interrupted.
+	pending, err := a.listPending(context.Background(), store)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected one pending file, got %v, %v", pending, err)
+	}
+	j, err := a.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportPath := filepath.Join(t.TempDir(), "ex-show.mp3")
+	if err := os.WriteFile(exportPath, []byte("tagged audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.recordStep(pending[0], a.pipelineKey(true), 2, exportPath); err != nil {
+		t.Fatal(err)
+	}
+
+	rerun := func(work Work) (string, error) {
+		t.Errorf("expected %s not to be processed again", work.Name)
+		return work.Path, nil
+	}
+	s := &session{
+		store: store,
+		live:  []Stage{funcStage{"audacity", true, rerun}, funcStage{"tag", false, rerun}},
+		close: func() {},
+	}
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 1 {
+		t.Fatalf("expected one file published, got %+v", report.Published)
+	}
+	if got, ok := srv.File(report.Published[0].Path); !ok || string(got) != "tagged audio" {
+		t.Fatalf("expected the journaled export to be uploaded, got %q", got)
+	}
+	j, err = a.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.entries) != 0 {
+		t.Fatalf("expected archived files to leave the journal, got %+v", j.entries)
+	}
+}
+
+func TestProcessPendingUploadsAgainWhenTheJournaledUploadIsGone(t *testing.T) {
+	a, srv, store := newTestApp(t, nil, testFile{"/automation/live/Host - Show.mp3", "raw audio"})
+	name := dropbox.RenameFile(dropbox.FileMetadata{Name: "Host - Show.mp3", ClientModified: testStamp})
+
+	// A previous run uploaded the file, which was deleted from the post
+	// folder before the archive copy was made.
+	pending, err := a.listPending(context.Background(), store)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected one pending file, got %v, %v", pending, err)
+	}
+	j, err := a.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportPath := filepath.Join(t.TempDir(), "ex-show.mp3")
+	if err := os.WriteFile(exportPath, []byte("tagged audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.recordStep(pending[0], a.pipelineKey(true), 1, exportPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(pending[0], progressUploaded, func(entry *journalEntry) {
+		entry.Uploaded, entry.UploadedPath = name, "/automation/post/"+strings.ToLower(name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &session{
+		store: store,
+		live: []Stage{funcStage{"audacity", true, func(work Work) (string, error) {
+			t.Errorf("expected %s not to be processed again", work.Name)
+			return work.Path, nil
+		}}},
+		close: func() {},
+	}
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 1 {
+		t.Fatalf("expected one file published, got %+v", report.Published)
+	}
+	if got, ok := srv.File("/automation/archive/" + name); !ok || string(got) != "tagged audio" {
+		t.Fatalf("expected the export to be uploaded and archived again, got %q", got)
+	}
+}
+
+func TestProcessPendingContinuesPastFailedFiles(t *testing.T) {
+	a, _, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Processing.ContinueOnError = true
+	}, testFile{"/automation/live/Corrupt - Show.mp3", "corrupt"}, testFile{"/automation/live/Host - Show.mp3", "raw audio"})
+	s := &session{
+		store: store,
+		live: []Stage{funcStage{"audacity", true, func(work Work) (string, error) {
+			raw, err := os.ReadFile(work.Path)
+			if err != nil {
+				return "", err
+			}
+			if string(raw) == "corrupt" {
+				return "", errors.New("audacity could not import the file")
+			}
+			return work.Path, nil
+		}}},
+		close: func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 1 || report.Published[0].Source != "Host - Show.mp3" {
+		t.Fatalf("expected the good file to be published, got %+v", report.Published)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Source != "Corrupt - Show.mp3" || report.Failed[0].Stage != "audacity" {
+		t.Fatalf("expected the corrupt file to fail in the audacity stage, got %+v", report.Failed)
+	}
+}
+
+func TestProcessPendingStopsAtFirstFailure(t *testing.T) {
+	a, srv, store := newTestApp(t, nil,
+		testFile{"/automation/live/A - Show.mp3", "first"},
+		testFile{"/automation/live/B - Show.mp3", "corrupt"},
+		testFile{"/automation/live/C - Show.mp3", "third"})
+	first := dropbox.RenameFile(dropbox.FileMetadata{Name: "A - Show.mp3", ClientModified: testStamp})
+	third := dropbox.RenameFile(dropbox.FileMetadata{Name: "C - Show.mp3", ClientModified: testStamp.Add(2 * time.Hour)})
+	s := &session{
+		store: store,
+		live: []Stage{funcStage{"audacity", true, func(work Work) (string, error) {
+			raw, err := os.ReadFile(work.Path)
+			if err != nil {
+				return "", err
+			}
+			if string(raw) != "corrupt" {
+				return work.Path, nil
+			}
+			// Fail only once the first file is uploaded, so the pass has
+			// something to archive when it stops.
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if _, ok := srv.File("/automation/post/" + first); ok {
+					break
+				}
+			}
+			return "", errors.New("audacity could not import the file")
+		}}},
+		close: func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err == nil || !strings.Contains(err.Error(), "B - Show.mp3") {
+		t.Fatalf("expected the failure to stop the run, got %v", err)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Stage != "audacity" || report.Err() == nil {
+		t.Fatalf("expected the corrupt file to be reported as failed, got %+v", report.Failed)
+	}
+	if len(report.Published) != 1 || report.Published[0].Name != first {
+		t.Fatalf("expected only the first file published, got %+v", report.Published)
+	}
+	if _, ok := srv.File("/automation/archive/" + first); !ok {
+		t.Fatal("expected the file uploaded before the failure to be archived")
+	}
+	if _, ok := srv.File("/automation/post/" + third); ok {
+		t.Fatal("expected no file after the failure to be uploaded")
+	}
+}
+
+func TestTaggingRunsInParallelWithAudacity(t *testing.T) {
+	a, _, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Processing.FFmpegWorkers = 2
+	}, testFile{"/automation/live/First - Show.mp3", "first"}, testFile{"/automation/live/Second - Show.mp3", "second"})
+	// Each tag waits for the other one to start, which only works if
+	// Audacity moved on to the second file and both tags run at once.
+	var tagging sync.WaitGroup
+	tagging.Add(2)
+	tag := func(work Work) (string, error) {
+		tagging.Done()
+		both := make(chan struct{})
+		go func() {
+			tagging.Wait()
+			close(both)
+		}()
+		select {
+		case <-both:
+			return work.Path, nil
+		case <-time.After(5 * time.Second):
+			return "", errors.New("tagging did not overlap")
+		}
+	}
+	s := &session{
+		store: store,
+		live:  []Stage{rewriteStage("audacity", true, ""), funcStage{"tag", false, tag}},
+		close: func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 2 {
+		t.Fatalf("expected both files published, got %+v", report.Published)
+	}
+}
+
+func TestFFmpegStagesShareTheWorkerPool(t *testing.T) {
+	a, _, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Processing.FFmpegWorkers = 2
+	}, testFile{"/automation/live/A - Show.mp3", "a"}, testFile{"/automation/live/B - Show.mp3", "b"},
+		testFile{"/automation/live/C - Show.mp3", "c"}, testFile{"/automation/live/D - Show.mp3", "d"})
+	var running, peak atomic.Int32
+	encode := func(work Work) (string, error) {
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return work.Path, nil
+	}
+	s := &session{
+		store: store,
+		live:  []Stage{funcStage{"tag", false, encode}, funcStage{"loudness", false, encode}, funcStage{"trim", false, encode}},
+		close: func() {},
+	}
+
+	report, err := a.processPending(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Published) != 4 {
+		t.Fatalf("expected every file published, got %+v", report.Published)
+	}
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("expected at most 2 ffmpeg runs at once across stages, got %d", got)
+	}
+}
+
+func TestBuildStagesFollowsPipelineConfig(t *testing.T) {
+	noJingle := false
+	a := New(config.Config{Pipeline: config.PipelineConfig{
+		Live: []config.StageConfig{
+			{Stage: config.StageTrim, ThresholdDB: -60},
+			{Stage: config.StageTag, Jingle: &noJingle},
+		},
+		Prerecord: []config.StageConfig{},
+	}})
+	if a.usesAudacity() {
+		t.Fatal("expected a pipeline without an audacity stage not to need Audacity")
+	}
+	live := buildStages(a.passStages(true), nil, []string{"/jingles/a.mp3"})
+	if len(live) != 2 || live[0].Name() != "trim" || live[1].Name() != "tag" || live[0].Serial() {
+		t.Fatalf("expected parallel trim then tag stages, got %+v", live)
+	}
+	if tag := live[1].(tagStage); len(tag.jingles) != 0 {
+		t.Fatalf("expected jingle = false to drop the jingles, got %v", tag.jingles)
+	}
+	if prerecord := buildStages(a.passStages(false), nil, nil); len(prerecord) != 0 {
+		t.Fatalf("expected an empty prerecord pipeline, got %+v", prerecord)
+	}
+	if defaults := New(config.Config{}).passStages(false); len(defaults) != 2 || defaults[0].Chain != config.ChainPrerecord {
+		t.Fatalf("expected the default prerecord pipeline, got %+v", defaults)
+	}
+}
+
+func TestRetryStopsWaitingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := time.Now()
+	err := retry(ctx, "upload", 3, time.Minute, func() error {
+		calls++
+		cancel()
+		return io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected cancellation wrapping the last error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected no attempt after cancellation, got %d", calls)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("expected retry to stop without waiting out the backoff")
+	}
+}
+
+// funcStage is a Stage that runs a function.
+type funcStage struct {
+	name   string
+	serial bool
+	run    func(work Work) (string, error)
+}
+
+func (s funcStage) Name() string { return s.name }
+func (s funcStage) Serial() bool { return s.serial }
+
+func (s funcStage) Run(ctx context.Context, work Work) (string, error) {
+	return s.run(work)
+}
+
+// rewriteStage writes prefix and the file's content to the scratch path,
+// like Audacity exporting a processed copy.
+func rewriteStage(name string, serial bool, prefix string) funcStage {
+	return funcStage{name, serial, func(work Work) (string, error) {
+		raw, err := os.ReadFile(work.Path)
+		if err != nil {
+			return "", err
+		}
+		return work.Scratch, os.WriteFile(work.Scratch, append([]byte(prefix), raw...), 0o644)
+	}}
+}
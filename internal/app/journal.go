@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+)
+
+// progress is how far a source got through a pass.
+type progress string
+
+const (
+	progressNone       progress = ""
+	progressDownloaded progress = "downloaded"
+	progressProcessed  progress = "processed"
+	progressUploaded   progress = "uploaded"
+	progressArchived   progress = "archived"
+)
+
+var progressOrder = map[progress]int{
+	progressNone:       0,
+	progressDownloaded: 1,
+	progressProcessed:  2,
+	progressUploaded:   3,
+	progressArchived:   4,
+}
+
+func (p progress) atLeast(other progress) bool {
+	return progressOrder[p] >= progressOrder[other]
+}
+
+// journalEntry is the last recorded state of one source. The artifact
+// fields accumulate as the source moves through the pass; the hashes let a
+// later run check that the local files are still the ones it wrote. Steps
+// counts the processing stages done, and Pipeline identifies the stages so
+// a changed pipeline starts processing over.
+type journalEntry struct {
+	Key          string    `json:"key"`
+	Source       string    `json:"source"`
+	Version      string    `json:"version"`
+	Stage        progress  `json:"stage"`
+	Import       string    `json:"import,omitempty"`
+	ImportHash   string    `json:"import_hash,omitempty"`
+	Pipeline     string    `json:"pipeline,omitempty"`
+	Steps        int       `json:"steps,omitempty"`
+	Export       string    `json:"export,omitempty"`
+	ExportHash   string    `json:"export_hash,omitempty"`
+	Uploaded     string    `json:"uploaded,omitempty"`
+	UploadedPath string    `json:"uploaded_path,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// journal records each source's stage as JSON lines in state.dir, so a run
+// that was interrupted resumes where it stopped instead of downloading and
+// processing again. Finished sources are dropped when the journal is next
+// opened. A nil journal records nothing.
+type journal struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]journalEntry
+}
+
+func openJournal(path string) (*journal, error) {
+	j := &journal{path: path, entries: map[string]journalEntry{}}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		// A crash can leave a torn last line; everything before it counts.
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Stage == progressArchived {
+			delete(j.entries, entry.Key)
+			continue
+		}
+		j.entries[entry.Key] = entry
+	}
+	err = scanner.Err()
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read journal %s: %w", path, err)
+	}
+	return j, j.compact()
+}
+
+// compact rewrites the journal with only the latest entry per source.
+func (j *journal) compact() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := j.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, entry := range j.entries {
+		if err := encoder.Encode(entry); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, j.path)
+}
+
+func journalKey(file dropbox.FileMetadata) string {
+	if file.ID != "" {
+		return file.ID
+	}
+	return file.PathLower
+}
+
+// sourceVersion changes whenever the source is replaced, which invalidates
+// everything recorded for it.
+func sourceVersion(file dropbox.FileMetadata) string {
+	if file.Rev != "" {
+		return file.Rev
+	}
+	return fmt.Sprintf("%d-%d", file.Size, file.ClientModified.Unix())
+}
+
+// resume returns the recorded entry of file and how far its results can
+// still be used with pipeline: an upload needs nothing local, processing
+// steps need the same pipeline and the export file unchanged, and the
+// download needs the imported file unchanged.
+func (j *journal) resume(file dropbox.FileMetadata, pipeline string) (journalEntry, progress) {
+	return j.resumeFrom(file, pipeline, progressUploaded)
+}
+
+// resumeBeforeUpload is resume for a file whose recorded upload is gone:
+// only the local results still count.
+func (j *journal) resumeBeforeUpload(file dropbox.FileMetadata, pipeline string) (journalEntry, progress) {
+	return j.resumeFrom(file, pipeline, progressProcessed)
+}
+
+func (j *journal) resumeFrom(file dropbox.FileMetadata, pipeline string, latest progress) (journalEntry, progress) {
+	if j == nil {
+		return journalEntry{}, progressNone
+	}
+	j.mu.Lock()
+	entry, ok := j.entries[journalKey(file)]
+	j.mu.Unlock()
+	if !ok || entry.Version != sourceVersion(file) {
+		return journalEntry{}, progressNone
+	}
+	switch {
+	case latest.atLeast(progressUploaded) && entry.Stage.atLeast(progressUploaded):
+		return entry, progressUploaded
+	case entry.Stage.atLeast(progressProcessed) && entry.Pipeline == pipeline && unchanged(entry.Export, entry.ExportHash):
+		return entry, progressProcessed
+	case entry.Stage.atLeast(progressDownloaded) && unchanged(entry.Import, entry.ImportHash):
+		return entry, progressDownloaded
+	}
+	return journalEntry{}, progressNone
+}
+
+func unchanged(path, hash string) bool {
+	if path == "" || hash == "" {
+		return false
+	}
+	got, err := dropbox.FileContentHash(path)
+	return err == nil && got == hash
+}
+
+// record moves file to p, letting update fill in the results, and appends
+// the entry to the journal file.
+func (j *journal) record(file dropbox.FileMetadata, p progress, update func(*journalEntry)) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	key := journalKey(file)
+	entry := j.entries[key]
+	if entry.Version != sourceVersion(file) {
+		entry = journalEntry{}
+	}
+	entry.Key = key
+	entry.Source = file.PathLower
+	entry.Version = sourceVersion(file)
+	entry.Stage = p
+	entry.At = time.Now().UTC()
+	if update != nil {
+		update(&entry)
+	}
+	if p == progressArchived {
+		delete(j.entries, key)
+	} else {
+		j.entries[key] = entry
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// recordDownload records that file was downloaded to path.
+func (j *journal) recordDownload(file dropbox.FileMetadata, path string) error {
+	if j == nil {
+		return nil
+	}
+	hash, err := dropbox.FileContentHash(path)
+	if err != nil {
+		return err
+	}
+	return j.record(file, progressDownloaded, func(entry *journalEntry) {
+		entry.Import, entry.ImportHash = path, hash
+		entry.Pipeline, entry.Steps, entry.Export, entry.ExportHash = "", 0, "", ""
+	})
+}
+
+// recordStep records that the first steps stages of pipeline are done and
+// left their result at path.
+func (j *journal) recordStep(file dropbox.FileMetadata, pipeline string, steps int, path string) error {
+	if j == nil {
+		return nil
+	}
+	hash, err := dropbox.FileContentHash(path)
+	if err != nil {
+		return err
+	}
+	return j.record(file, progressProcessed, func(entry *journalEntry) {
+		entry.Pipeline, entry.Steps = pipeline, steps
+		entry.Export, entry.ExportHash = path, hash
+	})
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+
+	"radiobuenavia/internal/audacity"
+	"radiobuenavia/internal/audio"
+	"radiobuenavia/internal/config"
+)
+
+// Stage is one processing step a pass runs between downloading a source and
+// uploading the result. The steps of a pass come from its [pipeline] list.
+type Stage interface {
+	// Name identifies the stage in logs and the failure summary.
+	Name() string
+	// Serial reports whether the stage has to handle one file at a time,
+	// as Audacity does with its single pipe. Other stages share the
+	// processing.ffmpeg_workers pool.
+	Serial() bool
+	// Run processes work and returns the path of the result, which is
+	// either work.Path changed in place or work.Scratch.
+	Run(ctx context.Context, work Work) (string, error)
+}
+
+// Work is a file handed to a Stage. Name is the name it will be published
+// under; Path is the current file and Scratch a free path next to it.
+type Work struct {
+	Name    string
+	Path    string
+	Scratch string
+}
+
+// buildStages turns the configured stages of a pass into Stages. pipe is
+// only used by Audacity stages and may be nil without them.
+func buildStages(stages []config.StageConfig, pipe *audacity.PipeClient, jingles []string) []Stage {
+	out := make([]Stage, 0, len(stages))
+	for _, stage := range stages {
+		switch stage.Stage {
+		case config.StageAudacity:
+			out = append(out, audacityStage{pipe: pipe, live: stage.Chain == config.ChainLive})
+		case config.StageTag:
+			tag := tagStage{}
+			if stage.Jingle == nil || *stage.Jingle {
+				tag.jingles = jingles
+			}
+			out = append(out, tag)
+		case config.StageLoudness:
+			out = append(out, loudnessStage{targetLUFS: stage.TargetLUFS, truePeak: stage.TruePeak})
+		case config.StageTrim:
+			out = append(out, trimStage{thresholdDB: stage.ThresholdDB})
+		}
+	}
+	return out
+}
+
+// passStages returns the configured stages of the live or prerecord pass.
+func (a *App) passStages(live bool) []config.StageConfig {
+	stages := a.cfg.Pipeline.Prerecord
+	if live {
+		stages = a.cfg.Pipeline.Live
+	}
+	if stages == nil {
+		return config.DefaultStages(live)
+	}
+	return stages
+}
+
+// pipelineKey identifies the stages of a pass and their options, so the
+// journal can tell when a pipeline changed between runs.
+func (a *App) pipelineKey(live bool) string {
+	raw, err := json.Marshal(a.passStages(live))
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(raw)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// usesAudacity reports whether any pass runs an Audacity stage, so runs
+// without one do not need Audacity open.
+func (a *App) usesAudacity() bool {
+	for _, live := range []bool{true, false} {
+		for _, stage := range a.passStages(live) {
+			if stage.Stage == config.StageAudacity {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describeStage is how the dry-run plan lists a stage.
+func describeStage(stage config.StageConfig) string {
+	switch stage.Stage {
+	case config.StageAudacity:
+		return fmt.Sprintf("%s (%s)", stage.Stage, stage.Chain)
+	case config.StageTag:
+		if stage.Jingle != nil && !*stage.Jingle {
+			return stage.Stage + " (no jingle)"
+		}
+		return stage.Stage
+	case config.StageLoudness:
+		return fmt.Sprintf("%s (%g LUFS, %g dBTP)", stage.Stage, stage.TargetLUFS, stage.TruePeak)
+	case config.StageTrim:
+		return fmt.Sprintf("%s (%g dB)", stage.Stage, stage.ThresholdDB)
+	}
+	return stage.Stage
+}
+
+type audacityStage struct {
+	pipe *audacity.PipeClient
+	live bool
+}
+
+func (audacityStage) Name() string { return config.StageAudacity }
+func (audacityStage) Serial() bool { return true }
+
+func (s audacityStage) Run(ctx context.Context, work Work) (string, error) {
+	log.Printf("Processing %s...", work.Name)
+	if err := s.pipe.Process(work.Path, work.Scratch, s.live); err != nil {
+		return "", err
+	}
+	log.Print("Done!")
+	return work.Scratch, nil
+}
+
+// tagStage sets the artist tag and bitrate and prepends a jingle when it
+// has any.
+type tagStage struct {
+	jingles []string
+}
+
+func (tagStage) Name() string { return config.StageTag }
+func (tagStage) Serial() bool { return false }
+
+func (s tagStage) Run(ctx context.Context, work Work) (string, error) {
+	log.Printf("Setting artist name and potentially changing bitrate of %s", work.Name)
+	if err := audio.ProcessMetadataAndBitrate(ctx, work.Path, audio.GetArtist(work.Name), audio.PickJingle(s.jingles, work.Name)); err != nil {
+		return "", err
+	}
+	return work.Path, nil
+}
+
+type loudnessStage struct {
+	targetLUFS float64
+	truePeak   float64
+}
+
+func (loudnessStage) Name() string { return config.StageLoudness }
+func (loudnessStage) Serial() bool { return false }
+
+func (s loudnessStage) Run(ctx context.Context, work Work) (string, error) {
+	log.Printf("Normalizing %s to %g LUFS", work.Name, s.targetLUFS)
+	if err := audio.Normalize(ctx, work.Path, s.targetLUFS, s.truePeak); err != nil {
+		return "", err
+	}
+	return work.Path, nil
+}
+
+type trimStage struct {
+	thresholdDB float64
+}
+
+func (trimStage) Name() string { return config.StageTrim }
+func (trimStage) Serial() bool { return false }
+
+func (s trimStage) Run(ctx context.Context, work Work) (string, error) {
+	log.Printf("Trimming silence below %g dB from %s", s.thresholdDB, work.Name)
+	if err := audio.TrimSilence(ctx, work.Path, s.thresholdDB); err != nil {
+		return "", err
+	}
+	return work.Path, nil
+}
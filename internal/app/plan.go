@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"radiobuenavia/internal/audacity"
+	"radiobuenavia/internal/audio"
+	"radiobuenavia/internal/config"
+	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/storage"
+)
+
+// Plan is what a run would do with the files pending right now.
+type Plan struct {
+	Files []PlannedFile `json:"files"`
+}
+
+// PlannedFile is the plan for one source. Chain is the pass, "live" or
+// "prerecord", Stages are the stages it runs and Effects the Audacity
+// commands among them. ProcessedPath is only set when sources are moved
+// once done.
+type PlannedFile struct {
+	Source        string   `json:"source"`
+	OutputName    string   `json:"output_name"`
+	Chain         string   `json:"chain"`
+	Stages        []string `json:"stages"`
+	Effects       []string `json:"effects"`
+	Jingle        string   `json:"jingle,omitempty"`
+	UploadPath    string   `json:"upload_path"`
+	ArchivePath   string   `json:"archive_path"`
+	ProcessedPath string   `json:"processed_path,omitempty"`
+}
+
+// Plan lists the pending files and works out what a run would do with
+// them. It only reads from storage and never starts Audacity.
+func (a *App) Plan(ctx context.Context) (Plan, error) {
+	jingles, err := resolveJingles(a.cfg.Paths.Jingles, a.cfg.Paths.JinglesDir)
+	if err != nil {
+		return Plan{}, err
+	}
+	log.Printf("Connecting to %s storage...", a.cfg.Storage.Backend)
+	store, err := storage.NewReadOnly(a.cfg)
+	if err != nil {
+		return Plan{}, err
+	}
+	return a.plan(ctx, store, jingles)
+}
+
+func (a *App) plan(ctx context.Context, store storage.Backend, jingles []string) (Plan, error) {
+	pending, err := a.listPending(ctx, store)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{Files: []PlannedFile{}}
+	for _, pass := range []struct {
+		chain string
+		live  bool
+		path  string
+	}{
+		{"live", true, a.passPath(true)},
+		{"prerecord", false, a.passPath(false)},
+	} {
+		for _, file := range passFiles(pass.path, pending) {
+			plan.Files = append(plan.Files, a.planFile(file, pass.chain, pass.live, jingles))
+		}
+	}
+	return plan, nil
+}
+
+func (a *App) planFile(file dropbox.FileMetadata, chain string, live bool, jingles []string) PlannedFile {
+	name := withMp3Ext(dropbox.RenameFile(file))
+	planned := PlannedFile{
+		Source:      file.PathLower,
+		OutputName:  name,
+		Chain:       chain,
+		Stages:      []string{},
+		Effects:     []string{},
+		UploadPath:  path.Join(a.cfg.Paths.PostprocessSoundcloud, name),
+		ArchivePath: path.Join(a.cfg.Paths.PostprocessArchive, name),
+	}
+	for _, stage := range a.passStages(live) {
+		planned.Stages = append(planned.Stages, describeStage(stage))
+		switch stage.Stage {
+		case config.StageAudacity:
+			planned.Effects = append(planned.Effects, audacity.Effects(stage.Chain == config.ChainLive)...)
+		case config.StageTag:
+			if stage.Jingle == nil || *stage.Jingle {
+				planned.Jingle = audio.PickJingle(jingles, name)
+			}
+		}
+	}
+	if a.cfg.Paths.Processed != "" {
+		planned.ProcessedPath = path.Join(a.cfg.Paths.Processed, file.Name)
+	}
+	return planned
+}
+
+// WriteJSON writes the plan as indented JSON.
+func (p Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WriteText writes the plan for people to read.
+func (p Plan) WriteText(w io.Writer) error {
+	if len(p.Files) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing to process.")
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Plan (%d):\n", len(p.Files)); err != nil {
+		return err
+	}
+	for _, file := range p.Files {
+		lines := []string{
+			"\n" + file.Source,
+			"  output:    " + file.OutputName,
+			"  chain:     " + file.Chain,
+			"  stages:    " + strings.Join(file.Stages, " > "),
+		}
+		if len(file.Effects) > 0 {
+			lines = append(lines, "  effects:   "+strings.Join(file.Effects, "; "))
+		}
+		if file.Jingle != "" {
+			lines = append(lines, "  jingle:    "+file.Jingle)
+		}
+		lines = append(lines,
+			"  upload:    "+file.UploadPath,
+			"  archive:   "+file.ArchivePath,
+		)
+		if file.ProcessedPath != "" {
+			lines = append(lines, "  processed: "+file.ProcessedPath)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(lines, "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"radiobuenavia/internal/audio"
+	"radiobuenavia/internal/config"
+)
+
+func TestPlanDescribesEveryPendingFile(t *testing.T) {
+	a, srv, store := newTestApp(t, func(cfg *config.Config) {
+		cfg.Paths.PreprocessPrerecord = "/automation/prerecord"
+	}, testFile{"/automation/live/Host - Show.mp3", "live audio"}, testFile{"/automation/prerecord/Guest - Mix.mp3", "prerecorded audio"})
+	jingles := []string{"/jingles/a.mp3", "/jingles/b.mp3"}
+	plan, err := a.plan(context.Background(), store, jingles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Files []map[string]any `json:"files"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Files) != 2 {
+		t.Fatalf("expected both files in the plan, got %s", buf.String())
+	}
+	live := decoded.Files[0]
+	name := "Host - Show - Radio Buena Vida 09.03.24.mp3"
+	if live["source"] != "/automation/live/host - show.mp3" || live["chain"] != "live" || live["output_name"] != name ||
+		live["upload_path"] != "/automation/post/"+name || live["archive_path"] != "/automation/archive/"+name {
+		t.Fatalf("unexpected live plan %v", live)
+	}
+	if decoded.Files[1]["chain"] != "prerecord" {
+		t.Fatalf("expected the prerecord chain second, got %v", decoded.Files[1])
+	}
+	if plan.Files[0].Jingle != audio.PickJingle(jingles, name) {
+		t.Fatalf("expected the jingle a run would pick, got %q", plan.Files[0].Jingle)
+	}
+	if srv.Calls("/2/files/upload") != 0 || srv.Calls("/2/files/download") != 0 {
+		t.Fatal("expected a dry run to only list files")
+	}
+}
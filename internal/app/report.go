@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Report describes what a run published and which files failed.
+type Report struct {
+	Published []Published
+	Failed    []Failure
+}
+
+// Published is one file uploaded to postprocess_soundcloud. SharedLink is
+// empty unless sharing is enabled and the link could be created.
+type Published struct {
+	Source     string
+	Name       string
+	Path       string
+	SharedLink string
+}
+
+// Failure is a file that could not be published. Stage is the step that
+// failed: download, upload, archive or the name of a pipeline stage
+// (audacity, tag, loudness or trim).
+type Failure struct {
+	Source string
+	Stage  string
+	Err    error
+}
+
+func (r *Report) add(other Report) {
+	r.Published = append(r.Published, other.Published...)
+	r.Failed = append(r.Failed, other.Failed...)
+}
+
+// Err returns an error when any file failed, so a run that published
+// everything else still ends with a non-zero exit.
+func (r Report) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d file(s) could not be processed", len(r.Failed))
+}
+
+func (r Report) print(w io.Writer) {
+	r.printPublished(w)
+	r.printFailed(w)
+}
+
+func (r Report) printPublished(w io.Writer) {
+	if len(r.Published) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "\nPublished (%d):\n\n", len(r.Published))
+	for _, file := range r.Published {
+		if file.SharedLink == "" {
+			_, _ = fmt.Fprintf(w, "%s\n", file.Name)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s -> %s\n", file.Name, file.SharedLink)
+	}
+}
+
+func (r Report) printFailed(w io.Writer) {
+	if len(r.Failed) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "\nFailed (%d):\n\n", len(r.Failed))
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "SOURCE\tSTAGE\tERROR")
+	for _, failure := range r.Failed {
+		// Keep each failure on one row; wrapped errors can span lines.
+		message := strings.Join(strings.Fields(failure.Err.Error()), " ")
+		_, _ = fmt.Fprintf(table, "%s\t%s\t%s\n", failure.Source, failure.Stage, message)
+	}
+	_ = table.Flush()
+}
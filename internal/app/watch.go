@@ -0,0 +1,208 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/storage"
+)
+
+const longpollTimeout = 480 * time.Second
+
+// Watch processes whatever is pending, then waits on the preprocess folders
+// and runs the live and prerecord passes without prompting whenever new
+// uploads land. A run that fails is logged and watching goes on; Watch
+// returns once ctx is done or a folder can no longer be watched.
+func (a *App) Watch(ctx context.Context) error {
+	session, err := a.connect()
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	return a.watch(ctx, session)
+}
+
+func (a *App) watch(ctx context.Context, session *session) error {
+	watcher, ok := session.store.(storage.Watcher)
+	if !ok {
+		return fmt.Errorf("watch is not supported by the %s storage backend", a.cfg.Storage.Backend)
+	}
+
+	cursors, err := loadCursors(filepath.Join(a.cfg.State.Dir, "cursors.json"))
+	if err != nil {
+		return err
+	}
+	paths := a.preprocessPaths()
+	// Take cursors before the catch-up pass so uploads that land while it
+	// runs are still reported.
+	for _, path := range paths {
+		if cursors.get(path) != "" {
+			continue
+		}
+		cursor, err := watcher.LatestCursorContext(ctx, path)
+		if err != nil {
+			return err
+		}
+		if err := cursors.set(path, cursor); err != nil {
+			return err
+		}
+	}
+
+	if _, err := a.processPending(ctx, session, false); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Processing failed: %v", err)
+	}
+
+	watchCtx, stop := context.WithCancel(ctx)
+	var watchers sync.WaitGroup
+	defer func() {
+		stop()
+		watchers.Wait()
+	}()
+	changes := make(chan struct{}, 1)
+	errs := make(chan error, len(paths))
+	for _, path := range paths {
+		watchers.Add(1)
+		go func() {
+			defer watchers.Done()
+			watchFolder(watchCtx, watcher, cursors, path, changes, errs)
+		}()
+	}
+	log.Printf("Watching %s for new uploads...", strings.Join(paths, ", "))
+	for {
+		select {
+		case <-changes:
+			log.Print("New uploads detected.")
+			if _, err := a.processPending(ctx, session, false); err != nil && ctx.Err() == nil {
+				log.Printf("Processing failed: %v", err)
+			}
+			log.Print("Waiting for new uploads...")
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watchFolder longpolls one folder and signals changes whenever files were
+// added. Signals coalesce so a burst of uploads triggers a single pass. It
+// returns once ctx is done.
+func watchFolder(ctx context.Context, watcher storage.Watcher, cursors *cursorStore, path string, changes chan<- struct{}, errs chan<- error) {
+	failures := 0
+	for ctx.Err() == nil {
+		added, backoff, err := pollFolder(ctx, watcher, cursors, path)
+		if errors.Is(err, dropbox.ErrCursorReset) {
+			log.Printf("Cursor for %s was reset; relisting.", path)
+			added, err = true, resetCursor(ctx, watcher, cursors, path)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !isRetryableError(err) {
+				errs <- fmt.Errorf("watch %s failed: %w", path, err)
+				return
+			}
+			wait := retryDelay(err, 5*time.Second, min(failures, 5))
+			failures++
+			log.Printf("Watching %s failed: %v (retrying in %s)", path, err, wait.Round(time.Millisecond))
+			_ = sleep(ctx, wait)
+			continue
+		}
+		failures = 0
+		if added {
+			notify(changes)
+		}
+		_ = sleep(ctx, backoff)
+	}
+}
+
+func pollFolder(ctx context.Context, watcher storage.Watcher, cursors *cursorStore, path string) (bool, time.Duration, error) {
+	cursor := cursors.get(path)
+	changed, backoff, err := watcher.LongpollContext(ctx, cursor, longpollTimeout)
+	if err != nil || !changed {
+		return false, backoff, err
+	}
+	files, next, err := watcher.ListChangesContext(ctx, cursor)
+	if err != nil {
+		return false, backoff, err
+	}
+	if err := cursors.set(path, next); err != nil {
+		return false, backoff, err
+	}
+	return len(files) > 0, backoff, nil
+}
+
+func resetCursor(ctx context.Context, watcher storage.Watcher, cursors *cursorStore, path string) error {
+	cursor, err := watcher.LatestCursorContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	return cursors.set(path, cursor)
+}
+
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// cursorStore persists list_folder cursors so watch resumes where it left
+// off after a restart.
+type cursorStore struct {
+	mu      sync.Mutex
+	path    string
+	cursors map[string]string
+}
+
+func loadCursors(path string) (*cursorStore, error) {
+	store := &cursorStore{path: path, cursors: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.cursors); err != nil {
+		return nil, fmt.Errorf("cursor file %s is corrupt: %w", path, err)
+	}
+	return store, nil
+}
+
+func (s *cursorStore) get(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursors[strings.ToLower(path)]
+}
+
+func (s *cursorStore) set(path, cursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursors[strings.ToLower(path)] = cursor
+	data, err := json.MarshalIndent(s.cursors, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
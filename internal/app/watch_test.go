@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/storage"
+)
+
+// fakeWatcher adds scripted folder changes to a backend. longpoll is called
+// with the number of the call, starting at 1.
+type fakeWatcher struct {
+	storage.Backend
+	latest   atomic.Int32
+	polling  atomic.Int32
+	polls    atomic.Int32
+	longpoll func(ctx context.Context, call int, cursor string) (bool, error)
+}
+
+func (w *fakeWatcher) LatestCursorContext(ctx context.Context, path string) (string, error) {
+	w.latest.Add(1)
+	return "latest", nil
+}
+
+func (w *fakeWatcher) ListChangesContext(ctx context.Context, cursor string) ([]dropbox.FileMetadata, string, error) {
+	return []dropbox.FileMetadata{{Name: "new.mp3"}}, cursor + "+1", nil
+}
+
+func (w *fakeWatcher) LongpollContext(ctx context.Context, cursor string, timeout time.Duration) (bool, time.Duration, error) {
+	w.polling.Add(1)
+	defer w.polling.Add(-1)
+	changed, err := w.longpoll(ctx, int(w.polls.Add(1)), cursor)
+	return changed, 0, err
+}
+
+// waitForChanges is a longpoll that blocks until ctx is done, like one with
+// nothing to report.
+func waitForChanges(ctx context.Context) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestWatchKeepsGoingAfterAFailedRun(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// pending puts the file in place before watch starts, so the
+		// catch-up run is the one that fails.
+		pending bool
+	}{
+		{name: "change"},
+		{name: "catch-up", pending: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, srv, store := newTestApp(t, nil)
+			srv.Mkdir("/automation/live")
+			name := dropbox.RenameFile(dropbox.FileMetadata{Name: "Host - Show.mp3", ClientModified: testStamp})
+			upload := func() {
+				srv.PutFile("/automation/live/Host - Show.mp3", []byte("raw audio"), testStamp)
+			}
+			if tc.pending {
+				upload()
+			}
+			failed := make(chan struct{})
+			// Report a change only once the first run failed.
+			afterFailure := func(ctx context.Context) (bool, error) {
+				select {
+				case <-failed:
+					return true, nil
+				case <-ctx.Done():
+					return false, ctx.Err()
+				}
+			}
+			var runs atomic.Int32
+			process := rewriteStage("audacity", true, "processed ")
+			s := &session{
+				store: &fakeWatcher{Backend: store, longpoll: func(ctx context.Context, call int, cursor string) (bool, error) {
+					switch {
+					case call == 1 && tc.pending:
+						return afterFailure(ctx)
+					case call == 1:
+						upload()
+						return true, nil
+					case call == 2 && !tc.pending:
+						return afterFailure(ctx)
+					}
+					return waitForChanges(ctx)
+				}},
+				live: []Stage{funcStage{"audacity", true, func(work Work) (string, error) {
+					if runs.Add(1) == 1 {
+						close(failed)
+						return "", errors.New("audacity crashed")
+					}
+					return process.run(work)
+				}}},
+				close: func() {},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				done <- a.watch(ctx, s)
+			}()
+
+			deadline := time.After(5 * time.Second)
+			for {
+				if _, ok := srv.File("/automation/archive/" + name); ok {
+					break
+				}
+				select {
+				case err := <-done:
+					t.Fatalf("expected watch to keep going after the failed run, got %v", err)
+				case <-deadline:
+					t.Fatal("expected the next change to process the file")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			cancel()
+			if err := <-done; !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected watch to stop with the context, got %v", err)
+			}
+			if polling := s.store.(*fakeWatcher).polling.Load(); polling != 0 {
+				t.Fatalf("expected no longpoll to outlive watch, got %d", polling)
+			}
+		})
+	}
+}
+
+func TestWatchResumesFromSavedCursors(t *testing.T) {
+	a, srv, store := newTestApp(t, nil)
+	srv.Mkdir("/automation/live")
+	cursors := make(chan string, 4)
+	run := func() *fakeWatcher {
+		t.Helper()
+		watcher := &fakeWatcher{Backend: store, longpoll: func(ctx context.Context, call int, cursor string) (bool, error) {
+			cursors <- cursor
+			if call == 1 {
+				return true, nil
+			}
+			return waitForChanges(ctx)
+		}}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- a.watch(ctx, &session{store: watcher, close: func() {}})
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-cursors:
+			case err := <-done:
+				t.Fatalf("watch stopped early: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected watch to longpoll twice")
+			}
+		}
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected watch to stop with the context, got %v", err)
+		}
+		return watcher
+	}
+
+	if first := run(); first.latest.Load() != 1 {
+		t.Fatalf("expected the first watch to take the latest cursor, got %d calls", first.latest.Load())
+	}
+
+	// After a restart watch picks up from the cursor the last change left.
+	restarted := &fakeWatcher{Backend: store, longpoll: func(ctx context.Context, call int, cursor string) (bool, error) {
+		cursors <- cursor
+		return waitForChanges(ctx)
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- a.watch(ctx, &session{store: restarted, close: func() {}})
+	}()
+	select {
+	case cursor := <-cursors:
+		if cursor != "latest+1" {
+			t.Fatalf("expected the saved cursor after a restart, got %q", cursor)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the restarted watch to longpoll")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected watch to stop with the context, got %v", err)
+	}
+	if restarted.latest.Load() != 0 {
+		t.Fatal("expected the restarted watch not to take a new cursor")
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"radiobuenavia/internal/config"
+)
+
+// WebhookPath is where ServeWebhook expects Dropbox to send notifications.
+const WebhookPath = "/webhook"
+
+// webhookSettle is how long a notification waits for the rest of its burst
+// before the passes run.
+const webhookSettle = 2 * time.Second
+
+const maxWebhookBody = 1 << 20
+
+// ServeWebhook processes whatever is pending, then serves Dropbox webhook
+// notifications on addr and runs the live and prerecord passes without
+// prompting after each burst of them. Runs never overlap; notifications
+// that arrive during a run lead to one more run once it is done. It returns
+// once ctx is done or the server fails.
+func (a *App) ServeWebhook(ctx context.Context, addr string) error {
+	if a.cfg.Storage.Backend != config.BackendDropbox {
+		return fmt.Errorf("serve-webhook is not supported by the %s storage backend", a.cfg.Storage.Backend)
+	}
+	if a.cfg.Auth.AppSecret == "" {
+		return errors.New("serve-webhook needs auth.app_secret to verify Dropbox notifications")
+	}
+	session, err := a.connect()
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	// Listen before the catch-up pass so notifications sent while it runs
+	// are not lost.
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	changes := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.Handle(WebhookPath, webhookHandler(a.cfg.Auth.AppSecret, func() {
+		notify(changes)
+	}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Listening for Dropbox notifications on %s%s...", listener.Addr(), WebhookPath)
+
+	if _, err := a.processPending(ctx, session, false); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Processing failed: %v", err)
+	}
+
+	runsDone := make(chan struct{})
+	go func() {
+		defer close(runsDone)
+		runCoalesced(ctx, changes, webhookSettle, func() {
+			log.Print("Dropbox reported changes.")
+			if _, err := a.processPending(ctx, session, false); err != nil && ctx.Err() == nil {
+				log.Printf("Processing failed: %v", err)
+			}
+			log.Print("Waiting for notifications...")
+		})
+	}()
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	stop()
+	<-runsDone
+	return err
+}
+
+// runCoalesced calls run once per burst of signals on changes, waiting
+// settle after the first signal so the rest of the burst is folded in. It
+// runs one call at a time and returns once ctx is done.
+func runCoalesced(ctx context.Context, changes <-chan struct{}, settle time.Duration, run func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+		if err := sleep(ctx, settle); err != nil {
+			return
+		}
+		select {
+		case <-changes:
+		default:
+		}
+		run()
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// webhookHandler answers Dropbox's verification challenge and calls notify
+// for every notification signed with secret. Dropbox expects an answer
+// within ten seconds, so the passes never run inside the request.
+func webhookHandler(secret string, notify func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			challenge := r.URL.Query().Get("challenge")
+			if challenge == "" {
+				http.Error(w, "missing challenge", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			_, _ = io.WriteString(w, challenge)
+		case http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+			if err != nil {
+				http.Error(w, "could not read body", http.StatusBadRequest)
+				return
+			}
+			if !validSignature(secret, body, r.Header.Get("X-Dropbox-Signature")) {
+				log.Printf("Rejected webhook request from %s: invalid signature", r.RemoteAddr)
+				http.Error(w, "invalid signature", http.StatusForbidden)
+				return
+			}
+			notify()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// validSignature checks signature, the hex HMAC-SHA256 of body keyed with
+// the app secret, as Dropbox sends it in X-Dropbox-Signature.
+func validSignature(secret string, body []byte, signature string) bool {
+	want, err := hex.DecodeString(signature)
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
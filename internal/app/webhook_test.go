@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandlerChallengeAndSignature(t *testing.T) {
+	notified := 0
+	handler := webhookHandler("app-secret", func() { notified++ })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, WebhookPath+"?challenge=abc123", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc123" {
+		t.Fatalf("expected the challenge to be echoed, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	body := `{"list_folder":{"accounts":["dbid:AAA"]},"delta":{"users":[12345]}}`
+	for _, signature := range []string{"", "zz", sign("wrong-secret", body)} {
+		req := httptest.NewRequest(http.MethodPost, WebhookPath, strings.NewReader(body))
+		req.Header.Set("X-Dropbox-Signature", signature)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected signature %q to be rejected, got %d", signature, rec.Code)
+		}
+	}
+	if notified != 0 {
+		t.Fatal("expected unsigned notifications to be ignored")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, WebhookPath, strings.NewReader(body))
+	req.Header.Set("X-Dropbox-Signature", sign("app-secret", body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || notified != 1 {
+		t.Fatalf("expected a signed notification to be accepted, got %d (notified %d)", rec.Code, notified)
+	}
+}
+
+func TestRunCoalescedFoldsBurstsAndNeverOverlaps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 1)
+	var runs, running, overlapped atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runCoalesced(ctx, changes, 20*time.Millisecond, func() {
+			if running.Add(1) > 1 {
+				overlapped.Store(1)
+			}
+			runs.Add(1)
+			started <- struct{}{}
+			<-release
+			running.Add(-1)
+		})
+	}()
+
+	for i := 0; i < 5; i++ {
+		notify(changes)
+	}
+	<-started
+	// Notifications during a run queue exactly one more run.
+	for i := 0; i < 5; i++ {
+		notify(changes)
+	}
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("expected two runs, got %d", got)
+	}
+	if overlapped.Load() != 0 {
+		t.Fatal("expected runs never to overlap")
+	}
+}
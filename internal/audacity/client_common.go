@@ -38,12 +38,8 @@ func (p *PipeClient) Process(importPath, exportPath string, live bool) error {
 	if _, err := p.doCommand(cmdSelectAll); err != nil {
 		return err
 	}
-	if live {
-		if err := p.processLive(); err != nil {
-			return err
-		}
-	} else {
-		if err := p.processPrerecord(); err != nil {
+	for _, effect := range Effects(live) {
+		if _, err := p.doCommand(effect); err != nil {
 			return err
 		}
 	}
@@ -53,19 +49,13 @@ func (p *PipeClient) Process(importPath, exportPath string, live bool) error {
 	return p.cleanupTracks()
 }
 
-func (p *PipeClient) processPrerecord() error {
-	if _, err := p.doCommand(cmdPrerecordCompressor); err != nil {
-		return err
-	}
-	if _, err := p.doCommand(cmdPrerecordLimiter); err != nil {
-		return err
+// Effects returns the Audacity commands Process applies to a live or a
+// prerecorded show, in order.
+func Effects(live bool) []string {
+	if live {
+		return []string{cmdLiveNormalize}
 	}
-	return nil
-}
-
-func (p *PipeClient) processLive() error {
-	_, err := p.doCommand(cmdLiveNormalize)
-	return err
+	return []string{cmdPrerecordCompressor, cmdPrerecordLimiter}
 }
 
 func (p *PipeClient) cleanupTracks() error {
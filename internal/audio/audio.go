@@ -1,22 +1,16 @@
 package audio
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"math"
-	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
-)
-
-var (
-	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
-	rngMu sync.Mutex
 )
 
 type ffprobeOutput struct {
@@ -35,8 +29,22 @@ func GetArtist(path string) string {
 	return strings.TrimSuffix(base, ext)
 }
 
-func ProcessMetadataAndBitrate(path, artist string, jingles []string) error {
-	duration, bitrate, err := probeAudio(path)
+// PickJingle chooses the jingle for the file published as name. The choice
+// depends only on name, so a dry run shows the jingle a real run will use
+// while different shows still get different jingles.
+func PickJingle(jingles []string, name string) string {
+	if len(jingles) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return jingles[h.Sum32()%uint32(len(jingles))]
+}
+
+// ProcessMetadataAndBitrate sets the artist tag and bitrate of path and, if
+// jingle is set, puts the jingle in front of it.
+func ProcessMetadataAndBitrate(ctx context.Context, path, artist, jingle string) error {
+	duration, bitrate, err := probeAudio(ctx, path)
 	if err != nil {
 		return err
 	}
@@ -45,17 +53,47 @@ func ProcessMetadataAndBitrate(path, artist string, jingles []string) error {
 		bitrateK = "210k"
 	}
 
-	if len(jingles) > 0 {
-		rngMu.Lock()
-		jingle := jingles[rng.Intn(len(jingles))]
-		rngMu.Unlock()
-		return exportWithJingle(path, jingle, artist, bitrateK)
+	if jingle != "" {
+		return exportWithJingle(ctx, path, jingle, artist, bitrateK)
 	}
-	return exportWithMetadata(path, artist, bitrateK)
+	return exportWithMetadata(ctx, path, artist, bitrateK)
+}
+
+// Normalize brings path to the integrated loudness targetLUFS with true
+// peaks at most truePeak dBTP, keeping its bitrate.
+func Normalize(ctx context.Context, path string, targetLUFS, truePeak float64) error {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=11", targetLUFS, truePeak)
+	return exportWithFilter(ctx, path, filter, "loudness normalization")
+}
+
+// TrimSilence cuts the audio quieter than thresholdDB from the start and
+// the end of path, keeping its bitrate.
+func TrimSilence(ctx context.Context, path string, thresholdDB float64) error {
+	// silenceremove only trims reliably from the start, so the end is
+	// trimmed the same way with the audio reversed.
+	trim := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB", thresholdDB)
+	filter := strings.Join([]string{trim, "areverse", trim, "areverse"}, ",")
+	return exportWithFilter(ctx, path, filter, "silence trim")
+}
+
+func exportWithFilter(ctx context.Context, path, filter, operation string) error {
+	_, bitrate, err := probeAudio(ctx, path)
+	if err != nil {
+		return err
+	}
+	tmpPath, err := tempOutput(path)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", path, "-vn", "-af", filter, "-map_metadata", "0", "-codec:a", "libmp3lame", "-b:a", bitrateToK(bitrate), tmpPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg %s failed: %w; output: %s", operation, err, strings.TrimSpace(string(out)))
+	}
+	return replaceFile(tmpPath, path)
 }
 
-func probeAudio(path string) (float64, int, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-of", "json", "-show_entries", "format=duration,bit_rate:stream=bit_rate", "-select_streams", "a:0", path)
+func probeAudio(ctx context.Context, path string) (float64, int, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-of", "json", "-show_entries", "format=duration,bit_rate:stream=bit_rate", "-select_streams", "a:0", path)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return 0, 0, fmt.Errorf("ffprobe failed: %w; output: %s", err, strings.TrimSpace(string(out)))
@@ -83,26 +121,27 @@ func probeAudio(path string) (float64, int, error) {
 	return duration, bitrate, nil
 }
 
-func exportWithMetadata(path, artist, bitrate string) error {
+func exportWithMetadata(ctx context.Context, path, artist, bitrate string) error {
 	tmpPath, err := tempOutput(path)
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command("ffmpeg", "-y", "-i", path, "-vn", "-metadata", fmt.Sprintf("artist=%s", artist), "-codec:a", "libmp3lame", "-b:a", bitrate, tmpPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", path, "-vn", "-metadata", fmt.Sprintf("artist=%s", artist), "-codec:a", "libmp3lame", "-b:a", bitrate, tmpPath)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("ffmpeg export failed: %w; output: %s", err, strings.TrimSpace(string(out)))
 	}
 	return replaceFile(tmpPath, path)
 }
 
-func exportWithJingle(path, jingle, artist, bitrate string) error {
+func exportWithJingle(ctx context.Context, path, jingle, artist, bitrate string) error {
 	tmpPath, err := tempOutput(path)
 	if err != nil {
 		return err
 	}
 	// Concatenate jingle audio (input 0) followed by the track (input 1).
 	filter := "[0:a][1:a]concat=n=2:v=0:a=1[a]"
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"ffmpeg",
 		"-y",
 		"-i", jingle,
@@ -1,15 +1,27 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Auth  AuthConfig  `toml:"auth"`
-	Paths PathsConfig `toml:"paths"`
+	Auth       AuthConfig       `toml:"auth"`
+	Paths      PathsConfig      `toml:"paths"`
+	Storage    StorageConfig    `toml:"storage"`
+	State      StateConfig      `toml:"state"`
+	Conflicts  ConflictsConfig  `toml:"conflicts"`
+	Sharing    SharingConfig    `toml:"sharing"`
+	Timeouts   TimeoutsConfig   `toml:"timeouts"`
+	Limits     LimitsConfig     `toml:"limits"`
+	Processing ProcessingConfig `toml:"processing"`
+	Pipeline   PipelineConfig   `toml:"pipeline"`
 }
 
 type AuthConfig struct {
@@ -23,17 +35,171 @@ type PathsConfig struct {
 	PreprocessPrerecord   string   `toml:"preprocess_prerecord"`
 	PostprocessSoundcloud string   `toml:"postprocess_soundcloud"`
 	PostprocessArchive    string   `toml:"postprocess_archive"`
+	Processed             string   `toml:"processed"`
 	Jingles               []string `toml:"jingles"`
 	JinglesDir            string   `toml:"jingles_dir"`
 }
 
-func Load(path string) (Config, error) {
+type StorageConfig struct {
+	Backend   string `toml:"backend"`
+	LocalRoot string `toml:"local_root"`
+	// TeamSpace resolves Dropbox paths against the team space root instead
+	// of the member's personal folder.
+	TeamSpace bool `toml:"team_space"`
+}
+
+type StateConfig struct {
+	Dir string `toml:"dir"`
+	// Properties records processed sources in a Dropbox file_properties
+	// template instead of relying on archive names alone.
+	Properties bool `toml:"properties"`
+}
+
+// ConflictsConfig sets the write policy per destination: what to do when the
+// upload or archive copy already exists.
+type ConflictsConfig struct {
+	Soundcloud string `toml:"soundcloud"`
+	Archive    string `toml:"archive"`
+}
+
+// SharingConfig controls the shared links created for published files.
+// Expires is a duration such as "720h", counted from the upload.
+type SharingConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Expires  string `toml:"expires"`
+	Password string `toml:"password"`
+}
+
+// TimeoutsConfig bounds single storage requests. Request covers listing,
+// copies and other metadata calls. Transfer is how long a download or upload
+// may go without moving any bytes, so slow but steady transfers of large
+// files are never cut off. Values are durations such as "30s"; "0" disables
+// the limit.
+type TimeoutsConfig struct {
+	Request  string `toml:"request"`
+	Transfer string `toml:"transfer"`
+}
+
+// LimitsConfig caps the Dropbox traffic of a run, shared by every request.
+// Bandwidth is in megabits per second; zero leaves a limit off.
+type LimitsConfig struct {
+	RequestsPerSecond float64 `toml:"requests_per_second"`
+	UploadMbps        float64 `toml:"upload_mbps"`
+	DownloadMbps      float64 `toml:"download_mbps"`
+}
+
+// ProcessingConfig controls how a run handles the files of a batch.
+// ContinueOnError keeps going with the other files when one fails instead
+// of stopping the run. FFmpegWorkers is how many ffmpeg stage runs (tag,
+// loudness, trim) happen at once, across all stages of a pass, while
+// Audacity works on the next file; zero means one per CPU.
+type ProcessingConfig struct {
+	ContinueOnError bool `toml:"continue_on_error"`
+	FFmpegWorkers   int  `toml:"ffmpeg_workers"`
+}
+
+// PipelineConfig lists the processing stages each pass runs, in order,
+// between downloading a source and uploading the result. A pass that is
+// left out gets DefaultStages; an empty list uploads sources as they are.
+type PipelineConfig struct {
+	Live      []StageConfig `toml:"live"`
+	Prerecord []StageConfig `toml:"prerecord"`
+}
+
+// StageConfig is one stage of a pass. Stage names the kind; the other
+// fields are options and only apply to the kind noted next to them.
+type StageConfig struct {
+	Stage string `toml:"stage" json:"stage"`
+	// Chain is the Audacity effect chain, live or prerecord; it defaults
+	// to the pass's own.
+	Chain string `toml:"chain" json:"chain,omitempty"`
+	// Jingle puts a jingle in front of the tagged file; it defaults to true.
+	Jingle *bool `toml:"jingle" json:"jingle,omitempty"`
+	// TargetLUFS and TruePeak are the loudness stage's integrated loudness
+	// and true peak targets.
+	TargetLUFS float64 `toml:"target_lufs" json:"target_lufs,omitempty"`
+	TruePeak   float64 `toml:"true_peak" json:"true_peak,omitempty"`
+	// ThresholdDB is the level below which the trim stage treats audio at
+	// the start and end as silence.
+	ThresholdDB float64 `toml:"threshold_db" json:"threshold_db,omitempty"`
+}
+
+const (
+	StageAudacity = "audacity"
+	StageTag      = "tag"
+	StageLoudness = "loudness"
+	StageTrim     = "trim"
+)
+
+const (
+	ChainLive      = "live"
+	ChainPrerecord = "prerecord"
+)
+
+const (
+	DefaultTargetLUFS  = -16.0
+	DefaultTruePeak    = -1.5
+	DefaultThresholdDB = -50.0
+)
+
+// DefaultStages is the pipeline rbv has always run: the pass's Audacity
+// chain, then the artist tag, bitrate and jingle.
+func DefaultStages(live bool) []StageConfig {
+	chain := ChainPrerecord
+	if live {
+		chain = ChainLive
+	}
+	return []StageConfig{{Stage: StageAudacity, Chain: chain}, {Stage: StageTag}}
+}
+
+const (
+	DefaultRequestTimeout  = "1m"
+	DefaultTransferTimeout = "2m"
+)
+
+const (
+	PolicySkipIfIdentical = "skip-if-identical"
+	PolicyOverwrite       = "overwrite"
+	PolicyUpdateByRev     = "update-by-rev"
+	PolicyAutorename      = "autorename"
+)
+
+const (
+	BackendDropbox = "dropbox"
+	BackendLocal   = "local"
+)
+
+// Read decodes path without validating it or filling in defaults, for
+// commands that rewrite part of an existing config.
+func Read(path string) (Config, error) {
 	var cfg Config
 	if _, err := toml.DecodeFile(path, &cfg); err != nil {
 		return Config{}, err
 	}
-	if cfg.Auth.AppKey == "" || cfg.Auth.AppSecret == "" || cfg.Auth.RefreshToken == "" {
-		return Config{}, fmt.Errorf("auth config is missing required fields")
+	return cfg, nil
+}
+
+func Load(path string) (Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return Config{}, err
+	}
+	switch cfg.Storage.Backend {
+	case "", BackendDropbox:
+		cfg.Storage.Backend = BackendDropbox
+		// app_secret is optional: refresh tokens from the PKCE flow don't need it.
+		if cfg.Auth.AppKey == "" || cfg.Auth.RefreshToken == "" {
+			return Config{}, fmt.Errorf("auth config is missing required fields")
+		}
+	case BackendLocal:
+		if cfg.Storage.LocalRoot == "" {
+			return Config{}, fmt.Errorf("storage.local_root is required for the local backend")
+		}
+		if cfg.Storage.TeamSpace {
+			return Config{}, fmt.Errorf("storage.team_space requires the %s storage backend", BackendDropbox)
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown storage.backend %q", cfg.Storage.Backend)
 	}
 	if cfg.Paths.PreprocessLive == "" && cfg.Paths.PreprocessPrerecord == "" {
 		return Config{}, fmt.Errorf("paths.preprocess_live or paths.preprocess_prerecord must be set")
@@ -41,9 +207,136 @@ func Load(path string) (Config, error) {
 	if cfg.Paths.PostprocessSoundcloud == "" || cfg.Paths.PostprocessArchive == "" {
 		return Config{}, fmt.Errorf("paths.postprocess_soundcloud and paths.postprocess_archive are required")
 	}
+	for _, policy := range []*string{&cfg.Conflicts.Soundcloud, &cfg.Conflicts.Archive} {
+		switch *policy {
+		case "":
+			*policy = PolicySkipIfIdentical
+		case PolicySkipIfIdentical, PolicyOverwrite, PolicyUpdateByRev:
+		case PolicyAutorename:
+			// The archive copy is made under the uploaded name, and the
+			// archive name is how a source is recognised as done, so a file
+			// renamed on either side would be processed again on every run.
+			return Config{}, fmt.Errorf("conflict policy %s is not supported (want %s, %s or %s)", PolicyAutorename, PolicySkipIfIdentical, PolicyOverwrite, PolicyUpdateByRev)
+		default:
+			return Config{}, fmt.Errorf("unknown conflict policy %q (want %s, %s or %s)", *policy, PolicySkipIfIdentical, PolicyOverwrite, PolicyUpdateByRev)
+		}
+	}
+	if cfg.Sharing.Enabled && cfg.Storage.Backend != BackendDropbox {
+		return Config{}, fmt.Errorf("sharing requires the %s storage backend", BackendDropbox)
+	}
+	if cfg.State.Properties && cfg.Storage.Backend != BackendDropbox {
+		return Config{}, fmt.Errorf("state.properties requires the %s storage backend", BackendDropbox)
+	}
+	if cfg.Sharing.Expires != "" {
+		if d, err := time.ParseDuration(cfg.Sharing.Expires); err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("sharing.expires must be a positive duration such as \"720h\", got %q", cfg.Sharing.Expires)
+		}
+	}
+	for _, timeout := range []struct {
+		name  string
+		value *string
+		def   string
+	}{
+		{"timeouts.request", &cfg.Timeouts.Request, DefaultRequestTimeout},
+		{"timeouts.transfer", &cfg.Timeouts.Transfer, DefaultTransferTimeout},
+	} {
+		if *timeout.value == "" {
+			*timeout.value = timeout.def
+		}
+		if d, err := time.ParseDuration(*timeout.value); err != nil || d < 0 {
+			return Config{}, fmt.Errorf("%s must be a duration such as \"30s\", got %q", timeout.name, *timeout.value)
+		}
+	}
+	for _, limit := range []struct {
+		name  string
+		value float64
+	}{
+		{"limits.requests_per_second", cfg.Limits.RequestsPerSecond},
+		{"limits.upload_mbps", cfg.Limits.UploadMbps},
+		{"limits.download_mbps", cfg.Limits.DownloadMbps},
+	} {
+		if limit.value < 0 {
+			return Config{}, fmt.Errorf("%s must not be negative, got %v", limit.name, limit.value)
+		}
+	}
+	if cfg.Processing.FFmpegWorkers < 0 {
+		return Config{}, fmt.Errorf("processing.ffmpeg_workers must not be negative, got %d", cfg.Processing.FFmpegWorkers)
+	}
+	for _, pass := range []struct {
+		name   string
+		live   bool
+		stages *[]StageConfig
+	}{
+		{"pipeline.live", true, &cfg.Pipeline.Live},
+		{"pipeline.prerecord", false, &cfg.Pipeline.Prerecord},
+	} {
+		if *pass.stages == nil {
+			*pass.stages = DefaultStages(pass.live)
+		}
+		for i := range *pass.stages {
+			if err := fillStage(&(*pass.stages)[i], pass.live); err != nil {
+				return Config{}, fmt.Errorf("%s stage %d: %w", pass.name, i+1, err)
+			}
+		}
+	}
+	if cfg.State.Dir == "" {
+		cfg.State.Dir = defaultStateDir()
+	}
 	return cfg, nil
 }
 
+// fillStage checks the options of stage and fills in their defaults.
+func fillStage(stage *StageConfig, live bool) error {
+	switch stage.Stage {
+	case StageAudacity:
+		switch stage.Chain {
+		case "":
+			stage.Chain = ChainPrerecord
+			if live {
+				stage.Chain = ChainLive
+			}
+		case ChainLive, ChainPrerecord:
+		default:
+			return fmt.Errorf("unknown audacity chain %q (want %s or %s)", stage.Chain, ChainLive, ChainPrerecord)
+		}
+	case StageTag:
+		if stage.Jingle == nil {
+			jingle := true
+			stage.Jingle = &jingle
+		}
+	case StageLoudness:
+		if stage.TargetLUFS == 0 {
+			stage.TargetLUFS = DefaultTargetLUFS
+		}
+		if stage.TruePeak == 0 {
+			stage.TruePeak = DefaultTruePeak
+		}
+		if stage.TargetLUFS < -70 || stage.TargetLUFS > -5 {
+			return fmt.Errorf("target_lufs must be between -70 and -5, got %v", stage.TargetLUFS)
+		}
+		if stage.TruePeak < -9 || stage.TruePeak > 0 {
+			return fmt.Errorf("true_peak must be between -9 and 0, got %v", stage.TruePeak)
+		}
+	case StageTrim:
+		if stage.ThresholdDB == 0 {
+			stage.ThresholdDB = DefaultThresholdDB
+		}
+		if stage.ThresholdDB > 0 {
+			return fmt.Errorf("threshold_db must not be positive, got %v", stage.ThresholdDB)
+		}
+	default:
+		return fmt.Errorf("unknown stage %q (want %s, %s, %s or %s)", stage.Stage, StageAudacity, StageTag, StageLoudness, StageTrim)
+	}
+	return nil
+}
+
+func defaultStateDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "rbv")
+	}
+	return filepath.Join(os.TempDir(), "rbv-state")
+}
+
 func Save(path string, cfg Config) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -56,3 +349,103 @@ func Save(path string, cfg Config) error {
 	encoder := toml.NewEncoder(file)
 	return encoder.Encode(cfg)
 }
+
+// SaveAuth writes auth into the [auth] table of the config file at path and
+// leaves every other line, comments included, as it is. Keys already in the
+// table are replaced in place and missing ones are added after them; a file
+// without an [auth] table gets one at the top.
+func SaveAuth(path string, auth AuthConfig) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := []authValue{
+		{"app_key", auth.AppKey},
+		{"app_secret", auth.AppSecret},
+		{"refresh_token", auth.RefreshToken},
+	}
+	lines := make([]string, len(values))
+	for i, value := range values {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(map[string]string{value.key: value.value}); err != nil {
+			return err
+		}
+		lines[i] = strings.TrimRight(buf.String(), "\n")
+	}
+
+	var out []string
+	insert, inAuth := -1, false
+	written := make([]bool, len(values))
+	for _, line := range strings.Split(string(data), "\n") {
+		if table, ok := tableHeader(line); ok {
+			inAuth = table == "auth"
+			if inAuth && insert < 0 {
+				insert = len(out) + 1
+			}
+		} else if inAuth {
+			if i := authKey(line, values); i >= 0 {
+				if !written[i] {
+					out = append(out, lines[i])
+					written[i] = true
+					insert = len(out)
+				}
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	var missing []string
+	for i, line := range lines {
+		if !written[i] {
+			missing = append(missing, line)
+		}
+	}
+	if insert < 0 {
+		out = append(append([]string{"[auth]"}, append(missing, "")...), out...)
+	} else {
+		out = append(out[:insert], append(missing, out[insert:]...)...)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(out, "\n")), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+type authValue struct {
+	key   string
+	value string
+}
+
+// tableHeader returns the name of the table line opens, if it is a table
+// header.
+func tableHeader(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.Trim(line, "[]")), true
+}
+
+// authKey returns the index of the key in values that line sets, or -1.
+func authKey(line string, values []authValue) int {
+	key, _, ok := strings.Cut(line, "=")
+	if !ok {
+		return -1
+	}
+	key = strings.Trim(strings.TrimSpace(key), `"'`)
+	for i, value := range values {
+		if key == value.key {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveAuthKeepsTheRestOfTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	original := `# Radio Buena Vida
+[auth]
+app_key = "old-key" # from the app console
+refresh_token = "old-token"
+
+[paths]
+# Shared by both passes.
+preprocess_live = "/automation/preprocessed"
+`
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveAuth(path, AuthConfig{AppKey: "key", RefreshToken: "token \"quoted\""}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Radio Buena Vida
+[auth]
+app_key = "key"
+refresh_token = "token \"quoted\""
+app_secret = ""
+
+[paths]
+# Shared by both passes.
+preprocess_live = "/automation/preprocessed"
+`
+	if string(got) != want {
+		t.Fatalf("unexpected config:\n%s", got)
+	}
+	cfg, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.RefreshToken != `token "quoted"` || cfg.Paths.PreprocessLive != "/automation/preprocessed" {
+		t.Fatalf("unexpected config after saving auth: %+v", cfg)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the file mode to be kept, got %v, %v", info.Mode(), err)
+	}
+}
+
+func TestSaveAuthAddsMissingAuthTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[paths]\npreprocess_live = \"/live\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveAuth(path, AuthConfig{AppKey: "key", RefreshToken: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.AppKey != "key" || cfg.Auth.RefreshToken != "token" || cfg.Paths.PreprocessLive != "/live" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadRejectsAutorenameConflicts(t *testing.T) {
+	for _, table := range []string{"soundcloud", "archive"} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		content := `[auth]
+app_key = "key"
+refresh_token = "token"
+
+[paths]
+preprocess_live = "/live"
+postprocess_soundcloud = "/post"
+postprocess_archive = "/archive"
+
+[conflicts]
+` + table + ` = "autorename"
+`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "autorename") {
+			t.Fatalf("expected autorename to be rejected for %s, got %v", table, err)
+		}
+	}
+}
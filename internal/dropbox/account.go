@@ -1,6 +1,7 @@
 package dropbox
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -9,10 +10,20 @@ type Account struct {
 	AccountID string
 	Email     string
 	Name      string
+	// RootNamespaceID is the team space root for members of a team with
+	// team spaces, and the personal namespace otherwise.
+	RootNamespaceID string
+	HomeNamespaceID string
+	Team            bool
 }
 
 func (c *Client) GetCurrentAccount() (Account, error) {
-	resp, err := c.doAPIRequestNoBody("/2/users/get_current_account")
+	return c.GetCurrentAccountContext(context.Background())
+}
+
+// GetCurrentAccountContext is GetCurrentAccount with a context.
+func (c *Client) GetCurrentAccountContext(ctx context.Context) (Account, error) {
+	resp, err := c.doAPIRequestNoBody(ctx, "/2/users/get_current_account")
 	if err != nil {
 		return Account{}, err
 	}
@@ -22,6 +33,11 @@ func (c *Client) GetCurrentAccount() (Account, error) {
 		Name      struct {
 			DisplayName string `json:"display_name"`
 		} `json:"name"`
+		RootInfo struct {
+			Tag             string `json:".tag"`
+			RootNamespaceID string `json:"root_namespace_id"`
+			HomeNamespaceID string `json:"home_namespace_id"`
+		} `json:"root_info"`
 	}
 	if err := json.Unmarshal(resp, &payload); err != nil {
 		return Account{}, err
@@ -30,8 +46,30 @@ func (c *Client) GetCurrentAccount() (Account, error) {
 		return Account{}, fmt.Errorf("dropbox account response missing account_id")
 	}
 	return Account{
-		AccountID: payload.AccountID,
-		Email:     payload.Email,
-		Name:      payload.Name.DisplayName,
+		AccountID:       payload.AccountID,
+		Email:           payload.Email,
+		Name:            payload.Name.DisplayName,
+		RootNamespaceID: payload.RootInfo.RootNamespaceID,
+		HomeNamespaceID: payload.RootInfo.HomeNamespaceID,
+		Team:            payload.RootInfo.Tag == "team",
 	}, nil
 }
+
+// UseTeamSpace looks up the account's root namespace and resolves every
+// later path against it, so paths like /Radio/automation name team folders
+// instead of the member's personal folder.
+func (c *Client) UseTeamSpace() (Account, error) {
+	acct, err := c.GetCurrentAccount()
+	if err != nil {
+		return Account{}, err
+	}
+	if !acct.Team || acct.RootNamespaceID == "" {
+		return Account{}, fmt.Errorf("dropbox account %s is not a member of a team space", acct.Email)
+	}
+	pathRoot, err := json.Marshal(map[string]string{".tag": "root", "root": acct.RootNamespaceID})
+	if err != nil {
+		return Account{}, err
+	}
+	c.pathRoot = string(pathRoot)
+	return acct, nil
+}
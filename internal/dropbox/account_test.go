@@ -0,0 +1,122 @@
+package dropbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUseTeamSpaceSendsPathRootOnEveryRequest(t *testing.T) {
+	pathRoots := map[string]string{}
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				pathRoots[req.URL.Path] = req.Header.Get("Dropbox-API-Path-Root")
+				body := `{"entries":[],"has_more":false}`
+				switch req.URL.Path {
+				case "/2/users/get_current_account":
+					body = `{"account_id":"dbid:1","email":"host@example.com","root_info":{".tag":"team","root_namespace_id":"3235641","home_namespace_id":"3235642"}}`
+				case "/2/files/upload":
+					body = `{"name":"show.mp3","path_lower":"/radio/show.mp3","rev":"r1"}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	acct, err := c.UseTeamSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.RootNamespaceID != "3235641" {
+		t.Fatalf("unexpected root namespace %q", acct.RootNamespaceID)
+	}
+	if pathRoots["/2/users/get_current_account"] != "" {
+		t.Fatal("expected the account lookup to use the personal root")
+	}
+	if _, err := c.ListFilesContext(context.Background(), "/Radio/automation"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.doContentRequest(context.Background(), "/2/files/upload", []byte(`{"path":"/Radio/show.mp3"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	want := `{".tag":"root","root":"3235641"}`
+	for _, endpoint := range []string{"/2/files/list_folder", "/2/files/upload"} {
+		if pathRoots[endpoint] != want {
+			t.Fatalf("expected %s to send path root %s, got %q", endpoint, want, pathRoots[endpoint])
+		}
+	}
+}
+
+func TestUseTeamSpaceRejectsPersonalAccount(t *testing.T) {
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"account_id":"dbid:1","root_info":{".tag":"user","root_namespace_id":"1","home_namespace_id":"1"}}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+	if _, err := c.UseTeamSpace(); err == nil {
+		t.Fatal("expected an error for an account without a team space")
+	}
+	if c.pathRoot != "" {
+		t.Fatalf("expected no path root, got %q", c.pathRoot)
+	}
+}
+
+func TestAuthStatusReadsAccountAndScopes(t *testing.T) {
+	// rbv auth status refreshes the token, looks up the account and prints
+	// the scopes granted with the token.
+	c, err := NewClient("key", "", "refresh", WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body := ""
+			switch req.URL.Path {
+			case "/oauth2/token":
+				body = `{"access_token":"fresh","scope":"account_info.read files.metadata.read"}`
+			case "/2/users/get_current_account":
+				if got := req.Header.Get("Authorization"); got != "Bearer fresh" {
+					t.Fatalf("expected the refreshed token, got %q", got)
+				}
+				body = `{"account_id":"dbid:1","email":"host@example.com","name":{"display_name":"Radio Host"},"root_info":{".tag":"user","root_namespace_id":"1","home_namespace_id":"1"}}`
+			default:
+				t.Fatalf("unexpected endpoint %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acct, err := c.GetCurrentAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.AccountID != "dbid:1" || acct.Name != "Radio Host" || acct.Email != "host@example.com" || acct.Team {
+		t.Fatalf("unexpected account %+v", acct)
+	}
+	if got := strings.Join(c.Scopes(), " "); got != "account_info.read files.metadata.read" {
+		t.Fatalf("unexpected scopes %q", got)
+	}
+}
@@ -0,0 +1,169 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MaxCopyBatch is the most entries Dropbox accepts in one copy_batch_v2 call.
+const MaxCopyBatch = 1000
+
+// batchPollInterval is how long to wait before the first check_v2 call. It
+// doubles while the job is in progress, up to batchPollMaxInterval.
+var (
+	batchPollInterval    = time.Second
+	batchPollMaxInterval = 10 * time.Second
+)
+
+// CopyResult is the outcome of one entry of a batched archive copy.
+type CopyResult struct {
+	Name     string
+	Metadata FileMetadata
+	Err      error
+}
+
+// CopyBatchToArchiveContext copies soundcloudPath/name into archivePath for
+// every name with one copy_batch_v2 job and waits for it to finish. Entries
+// that hit an existing archive file are resolved one by one according to
+// policy, the same way CopyToArchiveContext resolves them. The returned error
+// covers the job as a whole; per-file failures are reported in the results.
+func (c *Client) CopyBatchToArchiveContext(ctx context.Context, names []string, soundcloudPath, archivePath string, policy WritePolicy) ([]CopyResult, error) {
+	switch policy {
+	case PolicySkipIfIdentical, PolicyOverwrite, PolicyUpdateByRev, PolicyAutorename, "":
+	default:
+		return nil, fmt.Errorf("unknown write policy %q", policy)
+	}
+	results := make([]CopyResult, 0, len(names))
+	for start := 0; start < len(names); start += MaxCopyBatch {
+		end := min(start+MaxCopyBatch, len(names))
+		batch, err := c.copyBatch(ctx, names[start:end], soundcloudPath, archivePath, policy)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+	return results, nil
+}
+
+func (c *Client) copyBatch(ctx context.Context, names []string, soundcloudPath, archivePath string, policy WritePolicy) ([]CopyResult, error) {
+	entries := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, map[string]string{
+			"from_path": c.remotePath(soundcloudPath, name),
+			"to_path":   c.remotePath(archivePath, name),
+		})
+	}
+	payload, err := json.Marshal(map[string]any{
+		"entries":    entries,
+		"autorename": policy == PolicyAutorename,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/files/copy_batch_v2", payload)
+	if err != nil {
+		return nil, err
+	}
+	status, err := decodeBatchStatus(resp)
+	if err != nil {
+		return nil, err
+	}
+	if status.Tag == "async_job_id" {
+		status, err = c.waitForCopyBatch(ctx, status.AsyncJobID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if status.Tag != "complete" {
+		return nil, fmt.Errorf("dropbox copy batch ended with status %q", status.Tag)
+	}
+	if len(status.Entries) != len(names) {
+		return nil, fmt.Errorf("dropbox copy batch returned %d results for %d files", len(status.Entries), len(names))
+	}
+
+	results := make([]CopyResult, len(names))
+	for i, entry := range status.Entries {
+		result := CopyResult{Name: names[i]}
+		switch entry.Tag {
+		case "success":
+			result.Metadata = entry.Success.metadata()
+		case "failure":
+			result.Err = batchEntryError(entry.Failure)
+			if errors.Is(result.Err, ErrConflict) && policy != PolicyAutorename {
+				result.Metadata, result.Err = c.resolveCopyConflict(ctx, entries[i]["from_path"], entries[i]["to_path"], policy)
+			}
+		default:
+			result.Err = fmt.Errorf("dropbox copy batch returned unknown entry status %q", entry.Tag)
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+func (c *Client) waitForCopyBatch(ctx context.Context, jobID string) (batchStatus, error) {
+	payload, err := json.Marshal(map[string]string{"async_job_id": jobID})
+	if err != nil {
+		return batchStatus{}, err
+	}
+	wait := batchPollInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return batchStatus{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		resp, err := c.doAPIRequest(ctx, "/2/files/copy_batch/check_v2", payload)
+		if err != nil {
+			return batchStatus{}, err
+		}
+		status, err := decodeBatchStatus(resp)
+		if err != nil {
+			return batchStatus{}, err
+		}
+		switch status.Tag {
+		case "in_progress":
+			wait = min(wait*2, batchPollMaxInterval)
+		case "failed":
+			return batchStatus{}, batchEntryError(status.Failed)
+		default:
+			return status, nil
+		}
+	}
+}
+
+type batchStatus struct {
+	Tag        string `json:".tag"`
+	AsyncJobID string `json:"async_job_id"`
+	Entries    []struct {
+		Tag     string          `json:".tag"`
+		Success fileEntry       `json:"success"`
+		Failure json.RawMessage `json:"failure"`
+	} `json:"entries"`
+	Failed json.RawMessage `json:"failed"`
+}
+
+func decodeBatchStatus(resp []byte) (batchStatus, error) {
+	var status batchStatus
+	if err := json.Unmarshal(resp, &status); err != nil {
+		return batchStatus{}, err
+	}
+	return status, nil
+}
+
+// batchEntryError turns a per-entry batch failure into an APIError so it
+// matches the same sentinel errors as a failed single request.
+func batchEntryError(raw json.RawMessage) *APIError {
+	body, _ := json.Marshal(map[string]json.RawMessage{"error": raw})
+	_, tags := parseErrorBody(body)
+	return &APIError{
+		Endpoint:   "/2/files/copy_batch/check_v2",
+		Method:     http.MethodPost,
+		StatusCode: http.StatusConflict,
+		Body:       string(raw),
+		Tags:       tags,
+	}
+}
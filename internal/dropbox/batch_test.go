@@ -0,0 +1,66 @@
+package dropbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCopyBatchToArchivePollsJobAndReportsPerFile(t *testing.T) {
+	interval := batchPollInterval
+	batchPollInterval = time.Millisecond
+	t.Cleanup(func() { batchPollInterval = interval })
+
+	checks := 0
+	c, calls := fakeUploadClient(t, func(call uploadCall) (int, string) {
+		switch call.endpoint {
+		case "/2/files/copy_batch_v2":
+			return http.StatusOK, `{".tag":"async_job_id","async_job_id":"job1"}`
+		case "/2/files/copy_batch/check_v2":
+			checks++
+			if checks == 1 {
+				return http.StatusOK, `{".tag":"in_progress"}`
+			}
+			return http.StatusOK, `{".tag":"complete","entries":[
+				{".tag":"success","success":{".tag":"file","name":"a.mp3","path_lower":"/archive/a.mp3","rev":"r1"}},
+				{".tag":"failure","failure":{".tag":"relocation_error","relocation_error":{".tag":"to","to":{".tag":"conflict","conflict":{".tag":"file"}}}}},
+				{".tag":"failure","failure":{".tag":"relocation_error","relocation_error":{".tag":"from_lookup","from_lookup":{".tag":"not_found"}}}}
+			]}`
+		case "/2/files/get_metadata":
+			return http.StatusOK, `{".tag":"file","name":"b.mp3","path_lower":"/archive/b.mp3","rev":"r2","content_hash":"h"}`
+		}
+		t.Fatalf("unexpected endpoint %s", call.endpoint)
+		return 0, ""
+	})
+
+	results, err := c.CopyBatchToArchiveContext(context.Background(), []string{"a.mp3", "b.mp3", "c.mp3"}, "/post", "/archive", PolicySkipIfIdentical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks != 2 {
+		t.Fatalf("expected to poll until complete, got %d checks", checks)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	if results[0].Err != nil || results[0].Metadata.Rev != "r1" {
+		t.Fatalf("expected a.mp3 to be copied, got %+v", results[0])
+	}
+	if results[1].Err != nil || results[1].Metadata.Rev != "r2" {
+		t.Fatalf("expected identical b.mp3 to be kept, got %+v", results[1])
+	}
+	if !errors.Is(results[2].Err, ErrNotFound) {
+		t.Fatalf("expected c.mp3 to fail with ErrNotFound, got %v", results[2].Err)
+	}
+	copies := 0
+	for _, call := range *calls {
+		if call.endpoint == "/2/files/copy_batch_v2" {
+			copies++
+		}
+	}
+	if copies != 1 {
+		t.Fatalf("expected a single batch submission, got %d", copies)
+	}
+}
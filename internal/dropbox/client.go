@@ -2,6 +2,7 @@ package dropbox
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,23 +28,94 @@ type Client struct {
 	appKey       string
 	appSecret    string
 	refreshToken string
-	accessToken  string
 	client       *http.Client
+	// limiter paces every request; see send.
+	limiter *limiter
+
+	// apiTimeout bounds RPC requests. transferTimeout bounds how long an
+	// upload or download may go without moving any bytes. Zero means no
+	// limit.
+	apiTimeout      time.Duration
+	transferTimeout time.Duration
+
+	// apiBase, contentBase and notifyBase override Dropbox's hosts when set.
+	apiBase     string
+	contentBase string
+	notifyBase  string
+
+	// tokenMu guards accessToken. It is held for the whole refresh so the
+	// download and upload workers never refresh the same token twice.
+	tokenMu     sync.Mutex
+	accessToken string
+	scopes      []string
+
+	// pathRoot is the Dropbox-API-Path-Root header sent with every request
+	// once UseTeamSpace has been called.
+	pathRoot string
+
+	// templateID is rbv's file_properties template once
+	// UseProcessedTemplateContext has been called.
+	templateID string
 }
 
 type FileMetadata struct {
+	ID             string
 	Name           string
 	PathLower      string
 	ClientModified time.Time
+	ServerModified time.Time
+	Size           int64
+	Rev            string
+	ContentHash    string
+	// PublishedName overrides RenameFile when ResolveSources had to give the
+	// file a suffix to keep its name unique.
+	PublishedName string
+	// Processed is the state rbv recorded on the file, when listed with the
+	// processed-state template in use.
+	Processed *ProcessedState
 }
 
-func NewClient(appKey, appSecret, refreshToken string) (*Client, error) {
-	c := &Client{
-		appKey:       appKey,
-		appSecret:    appSecret,
-		refreshToken: refreshToken,
-		client:       &http.Client{},
+// fileEntry is the JSON shape of Dropbox file metadata as returned by
+// list_folder, upload commits and the Dropbox-API-Result header.
+type fileEntry struct {
+	Tag            string `json:".tag"`
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	PathLower      string `json:"path_lower"`
+	ClientModified string `json:"client_modified"`
+	ServerModified string `json:"server_modified"`
+	Size           int64  `json:"size"`
+	Rev            string `json:"rev"`
+	ContentHash    string `json:"content_hash"`
+
+	PropertyGroups []propertyGroup `json:"property_groups"`
+}
+
+func (e fileEntry) metadata() FileMetadata {
+	return FileMetadata{
+		ID:             e.ID,
+		Name:           e.Name,
+		PathLower:      e.PathLower,
+		ClientModified: parseTime(e.ClientModified),
+		ServerModified: parseTime(e.ServerModified),
+		Size:           e.Size,
+		Rev:            e.Rev,
+		ContentHash:    e.ContentHash,
+		Processed:      processedState(e.PropertyGroups),
 	}
+}
+
+// parseTime returns the zero time for missing or malformed timestamps.
+func parseTime(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
+
+func NewClient(appKey, appSecret, refreshToken string, opts ...Option) (*Client, error) {
+	c := newClient(appKey, appSecret, refreshToken, opts)
 	if err := c.refreshAccessToken(); err != nil {
 		return nil, err
 	}
@@ -50,63 +123,112 @@ func NewClient(appKey, appSecret, refreshToken string) (*Client, error) {
 }
 
 func (c *Client) refreshAccessToken() error {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	token, scopes, err := c.fetchAccessToken(context.Background())
+	if err != nil {
+		return err
+	}
+	c.accessToken = token
+	c.scopes = scopes
+	return nil
+}
+
+// refreshExpiredToken replaces stale with a fresh access token unless another
+// request already did so while this one was waiting for the lock.
+func (c *Client) refreshExpiredToken(ctx context.Context, stale string) error {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.accessToken != stale {
+		return nil
+	}
+	token, scopes, err := c.fetchAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	c.accessToken = token
+	c.scopes = scopes
+	return nil
+}
+
+// Scopes returns the scopes Dropbox granted with the current access token.
+func (c *Client) Scopes() []string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return append([]string(nil), c.scopes...)
+}
+
+func (c *Client) currentToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.accessToken
+}
+
+func (c *Client) fetchAccessToken(ctx context.Context) (string, []string, error) {
 	form := url.Values{}
 	form.Set("grant_type", "refresh_token")
 	form.Set("refresh_token", c.refreshToken)
+	if c.appSecret == "" {
+		// Tokens from the PKCE flow are refreshed with the app key alone.
+		form.Set("client_id", c.appKey)
+	}
 
-	req, err := http.NewRequest("POST", apiHost+"/oauth2/token", strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/oauth2/token"), strings.NewReader(form.Encode()))
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.SetBasicAuth(c.appKey, c.appSecret)
+	if c.appSecret != "" {
+		req.SetBasicAuth(c.appKey, c.appSecret)
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return newAPIError("/oauth2/token", resp, body)
+		return "", nil, newAPIError("/oauth2/token", resp, body)
 	}
 
 	var payload struct {
 		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return err
+		return "", nil, err
 	}
 	if payload.AccessToken == "" {
-		return errors.New("dropbox token response missing access_token")
+		return "", nil, errors.New("dropbox token response missing access_token")
 	}
-	c.accessToken = payload.AccessToken
-	return nil
+	return payload.AccessToken, strings.Fields(payload.Scope), nil
 }
 
-func (c *Client) ListFiles(path string) ([]FileMetadata, error) {
+func (c *Client) ListFilesContext(ctx context.Context, path string) ([]FileMetadata, error) {
 	type listFolderResponse struct {
-		Entries []struct {
-			Tag            string `json:".tag"`
-			Name           string `json:"name"`
-			PathLower      string `json:"path_lower"`
-			ClientModified string `json:"client_modified"`
-		} `json:"entries"`
-		Cursor  string `json:"cursor"`
-		HasMore bool   `json:"has_more"`
+		Entries []fileEntry `json:"entries"`
+		Cursor  string      `json:"cursor"`
+		HasMore bool        `json:"has_more"`
 	}
 
 	body := map[string]any{
 		"path": path,
 	}
+	if c.templateID != "" {
+		body["include_property_groups"] = map[string]any{
+			".tag":        "filter_some",
+			"filter_some": []string{c.templateID},
+		}
+	}
 	payload, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.doAPIRequest("/2/files/list_folder", payload)
+	resp, err := c.doAPIRequest(ctx, "/2/files/list_folder", payload)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +244,7 @@ func (c *Client) ListFiles(path string) ([]FileMetadata, error) {
 		if err != nil {
 			return nil, err
 		}
-		resp, err := c.doAPIRequest("/2/files/list_folder/continue", nextPayload)
+		resp, err := c.doAPIRequest(ctx, "/2/files/list_folder/continue", nextPayload)
 		if err != nil {
 			return nil, err
 		}
@@ -136,89 +258,207 @@ func (c *Client) ListFiles(path string) ([]FileMetadata, error) {
 	return files, nil
 }
 
-func extractFiles(entries []struct {
-	Tag            string `json:".tag"`
-	Name           string `json:"name"`
-	PathLower      string `json:"path_lower"`
-	ClientModified string `json:"client_modified"`
-},
-) []FileMetadata {
+func extractFiles(entries []fileEntry) []FileMetadata {
 	files := []FileMetadata{}
 	for _, entry := range entries {
 		if entry.Tag != "file" {
 			continue
 		}
-		parsed, err := time.Parse(time.RFC3339Nano, entry.ClientModified)
-		if err != nil {
-			parsed = time.Time{}
-		}
-		files = append(files, FileMetadata{
-			Name:           entry.Name,
-			PathLower:      entry.PathLower,
-			ClientModified: parsed,
-		})
+		files = append(files, entry.metadata())
 	}
 	return files
 }
 
 func (c *Client) RenameFile(file FileMetadata) string {
+	return RenameFile(file)
+}
+
+// RenameFile returns the published name for a source upload. Every storage
+// backend uses it so archive matching behaves the same everywhere.
+func RenameFile(file FileMetadata) string {
+	if file.PublishedName != "" {
+		return file.PublishedName
+	}
+	return baseName(file)
+}
+
+func baseName(file FileMetadata) string {
 	ext := filepath.Ext(file.Name)
 	base := strings.TrimSuffix(file.Name, ext)
 	stamp := file.ClientModified.Format("02.01.06")
 	return fmt.Sprintf("%s - Radio Buena Vida %s%s", base, stamp, ext)
 }
 
-func (c *Client) DownloadFile(localPath, dropboxPath string) error {
-	arg, err := json.Marshal(map[string]string{"path": dropboxPath})
-	if err != nil {
-		return err
+// DownloadFileContext streams file into localPath+".part", resuming from
+// whatever a previous attempt left behind, and renames it into place only once
+// the partial file is exactly file.Size bytes and matches its content_hash.
+func (c *Client) DownloadFileContext(ctx context.Context, localPath string, file FileMetadata) error {
+	partPath := localPath + partSuffix
+	for attempt := 0; ; attempt++ {
+		expectedHash, err := c.downloadPart(ctx, partPath, file)
+		if errors.Is(err, errRestartDownload) && attempt == 0 {
+			if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := verifyContentHash(partPath, expectedHash); err != nil {
+			_ = os.Remove(partPath)
+			return err
+		}
+		return os.Rename(partPath, localPath)
+	}
+}
+
+const partSuffix = ".part"
+
+var errRestartDownload = errors.New("partial download does not match remote file")
+
+func (c *Client) downloadPart(ctx context.Context, partPath string, file FileMetadata) (string, error) {
+	offset := int64(0)
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+	if file.Size > 0 && offset > file.Size {
+		return "", errRestartDownload
+	}
+	if file.Size > 0 && offset == file.Size {
+		return file.ContentHash, nil
 	}
 
-	resp, err := c.doContentRequest("/2/files/download", arg, nil)
+	arg, err := json.Marshal(map[string]string{"path": file.PathLower})
 	if err != nil {
-		return err
+		return "", err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.doContentRequestWithHeader(ctx, "/2/files/download", arg, nil, header)
+	if err != nil {
+		return "", err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
-	if resp.StatusCode != http.StatusOK {
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server ignored the range and is sending the whole file.
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		return "", errRestartDownload
+	default:
 		body, _ := io.ReadAll(resp.Body)
-		return newAPIError("/2/files/download", resp, body)
+		return "", newAPIError("/2/files/download", resp, body)
 	}
-	out, err := os.Create(localPath)
+
+	expected := file.Size
+	expectedHash := file.ContentHash
+	if result, ok := parseAPIResult(resp.Header); ok {
+		if expected > 0 && result.Size != expected {
+			return "", errRestartDownload
+		}
+		if expectedHash != "" && result.ContentHash != "" && result.ContentHash != expectedHash {
+			return "", errRestartDownload
+		}
+		expected = result.Size
+		if expectedHash == "" {
+			expectedHash = result.ContentHash
+		}
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return "", err
+	}
+	written, copyErr := io.Copy(out, resp.Body)
+	if err := out.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return "", copyErr
+	}
+	if got := offset + written; expected > 0 && got != expected {
+		if got > expected {
+			return "", errRestartDownload
+		}
+		return "", fmt.Errorf("download %s incomplete: got %d of %d bytes: %w", file.PathLower, got, expected, io.ErrUnexpectedEOF)
+	}
+	return expectedHash, nil
+}
+
+func parseAPIResult(header http.Header) (fileEntry, bool) {
+	raw := header.Get("Dropbox-API-Result")
+	if raw == "" {
+		return fileEntry{}, false
+	}
+	var result fileEntry
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return fileEntry{}, false
+	}
+	return result, true
+}
+
+func verifyContentHash(path, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	got, err := FileContentHash(path)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = out.Close()
-	}()
-	_, err = io.Copy(out, resp.Body)
-	return err
+	if got != expected {
+		return fmt.Errorf("%w: %s has %s, expected %s", ErrContentHashMismatch, path, got, expected)
+	}
+	return nil
 }
 
-func (c *Client) UploadFileSoundcloud(localPath, name, soundcloudPath string) error {
+func (c *Client) UploadFileSoundcloudContext(ctx context.Context, localPath, name, soundcloudPath string, policy WritePolicy) (FileMetadata, error) {
 	remotePath := c.remotePath(soundcloudPath, name)
-	return c.UploadFile(localPath, remotePath)
+	return c.UploadFileContext(ctx, localPath, remotePath, policy)
 }
 
-func (c *Client) CopyToArchive(name, soundcloudPath, archivePath string) error {
-	fromPath := c.remotePath(soundcloudPath, name)
-	toPath := c.remotePath(archivePath, name)
-	payload, err := json.Marshal(map[string]string{
-		"from_path": fromPath,
-		"to_path":   toPath,
-	})
+// UploadFileContext uploads localPath to remotePath, resolving an existing file
+// according to policy, and checks the committed content_hash against the
+// local file. A mismatched upload is deleted so the retry does not trip
+// over it.
+func (c *Client) UploadFileContext(ctx context.Context, localPath, remotePath string, policy WritePolicy) (FileMetadata, error) {
+	localHash, err := FileContentHash(localPath)
 	if err != nil {
-		return err
+		return FileMetadata{}, err
 	}
-	_, err = c.doAPIRequest("/2/files/copy_v2", payload)
-	return err
+	commit, existing, err := c.uploadCommit(ctx, remotePath, localHash, policy)
+	if err != nil || existing != nil {
+		if existing != nil {
+			return *existing, nil
+		}
+		return FileMetadata{}, err
+	}
+	committed, err := c.uploadFile(ctx, localPath, commit)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	if committed.ContentHash != localHash {
+		if committed.Rev != "" {
+			_ = c.deleteFile(ctx, committed.PathLower, committed.Rev)
+		}
+		return FileMetadata{}, fmt.Errorf("%w: uploaded %s has %s, expected %s", ErrContentHashMismatch, remotePath, committed.ContentHash, localHash)
+	}
+	return committed.metadata(), nil
 }
 
-func (c *Client) UploadFile(localPath, remotePath string) error {
+func (c *Client) uploadFile(ctx context.Context, localPath string, commit writeCommit) (fileEntry, error) {
 	file, err := os.Open(localPath)
 	if err != nil {
-		return err
+		return fileEntry{}, err
 	}
 	defer func() {
 		_ = file.Close()
@@ -226,160 +466,164 @@ func (c *Client) UploadFile(localPath, remotePath string) error {
 
 	info, err := file.Stat()
 	if err != nil {
-		return err
+		return fileEntry{}, err
 	}
 	fileSize := info.Size()
 
 	if fileSize <= chunkSize {
 		buf, err := io.ReadAll(file)
 		if err != nil {
-			return err
-		}
-		arg := map[string]any{
-			"path":       remotePath,
-			"mode":       "add",
-			"autorename": false,
-			"mute":       false,
-		}
-		payload, err := json.Marshal(arg)
-		if err != nil {
-			return err
+			return fileEntry{}, err
 		}
-		resp, err := c.doContentRequest("/2/files/upload", payload, buf)
+		payload, err := json.Marshal(commit)
 		if err != nil {
-			return err
+			return fileEntry{}, err
 		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return newAPIError("/2/files/upload", resp, body)
+		var committed fileEntry
+		if err := c.doContentRequestDecode(ctx, "/2/files/upload", payload, buf, &committed); err != nil {
+			return fileEntry{}, err
 		}
-		return nil
+		return committed, nil
 	}
 
-	startArg, err := json.Marshal(map[string]bool{"close": false})
-	if err != nil {
-		return err
+	return c.uploadSession(ctx, file, localPath, commit, info)
+}
+
+// deleteFile deletes path. With a non-empty rev the delete only happens if
+// the file is still at that rev.
+func (c *Client) deleteFile(ctx context.Context, path, rev string) error {
+	arg := map[string]string{"path": path}
+	if rev != "" {
+		arg["parent_rev"] = rev
 	}
-	startChunk := make([]byte, chunkSize)
-	n, err := io.ReadFull(file, startChunk)
-	if err != nil && err != io.ErrUnexpectedEOF {
+	payload, err := json.Marshal(arg)
+	if err != nil {
 		return err
 	}
-	startChunk = startChunk[:n]
-	resp, err := c.doContentRequest("/2/files/upload_session/start", startArg, startChunk)
+	_, err = c.doAPIRequest(ctx, "/2/files/delete_v2", payload)
+	return err
+}
+
+// ListFilesToProcessContext lists the sources in preprocessPaths that still
+// need processing. The folders are listed together so ResolveSources can
+// deduplicate and name them across all of them. Files that carry rbv's
+// processed state are done regardless of what the archive holds; for the others
+// the archive name decides.
+func (c *Client) ListFilesToProcessContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]FileMetadata, error) {
+	preproc, err := c.listSources(ctx, preprocessPaths)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return newAPIError("/2/files/upload_session/start", resp, body)
+	// A missing archive just means nothing has been processed yet.
+	archive, err := c.ListFilesContext(ctx, archivePath)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("list %s: %w", archivePath, err)
 	}
-	var startResp struct {
-		SessionID string `json:"session_id"`
+	archiveNames := make(map[string]FileMetadata, len(archive))
+	for _, file := range archive {
+		archiveNames[file.Name] = file
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&startResp); err != nil {
-		return err
+	preproc, err = ResolveArchiveNames(preproc, func(name string) (FileMetadata, bool, error) {
+		file, ok := archiveNames[name]
+		return file, ok, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if startResp.SessionID == "" {
-		return errors.New("dropbox upload session start missing session_id")
+	result := make([]FileMetadata, 0, len(preproc))
+	for _, file := range preproc {
+		if IsProcessed(file) {
+			continue
+		}
+		if _, exists := archiveNames[c.RenameFile(file)]; !exists {
+			result = append(result, file)
+		}
 	}
+	return result, nil
+}
 
-	offset := int64(n)
-	for offset < fileSize {
-		remaining := fileSize - offset
-		chunkSize64 := minInt64(remaining, int64(chunkSize))
-		chunk := make([]byte, int(chunkSize64))
-		read, err := io.ReadFull(file, chunk)
-		if err != nil && err != io.ErrUnexpectedEOF {
-			return err
-		}
-		chunk = chunk[:read]
-
-		if offset+int64(read) >= fileSize {
-			finishArg, err := json.Marshal(map[string]any{
-				"cursor": map[string]any{
-					"session_id": startResp.SessionID,
-					"offset":     offset,
-				},
-				"commit": map[string]any{
-					"path":       remotePath,
-					"mode":       "add",
-					"autorename": false,
-					"mute":       false,
-				},
-			})
-			if err != nil {
-				return err
-			}
-			finishResp, err := c.doContentRequest("/2/files/upload_session/finish", finishArg, chunk)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				_ = finishResp.Body.Close()
-			}()
-			if finishResp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(finishResp.Body)
-				return newAPIError("/2/files/upload_session/finish", finishResp, body)
+// ListPendingFilesContext is ListFilesToProcessContext for setups that move
+// sources out of the preprocess folder once they are done. The preprocess
+// folder then only holds pending files, so instead of listing the whole archive
+// each candidate's archive name is looked up directly.
+func (c *Client) ListPendingFilesContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]FileMetadata, error) {
+	preproc, err := c.listSources(ctx, preprocessPaths)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]*FileMetadata)
+	lookup := func(name string) (FileMetadata, bool, error) {
+		if file, ok := archived[name]; ok {
+			if file == nil {
+				return FileMetadata{}, false, nil
 			}
-			return nil
+			return *file, true, nil
 		}
-
-		appendArg, err := json.Marshal(map[string]any{
-			"cursor": map[string]any{
-				"session_id": startResp.SessionID,
-				"offset":     offset,
-			},
-			"close": false,
-		})
-		if err != nil {
-			return err
+		file, err := c.GetMetadataContext(ctx, c.remotePath(archivePath, name))
+		if errors.Is(err, ErrNotFound) {
+			archived[name] = nil
+			return FileMetadata{}, false, nil
 		}
-		appendResp, err := c.doContentRequest("/2/files/upload_session/append_v2", appendArg, chunk)
 		if err != nil {
-			return err
+			return FileMetadata{}, false, err
+		}
+		archived[name] = &file
+		return file, true, nil
+	}
+	preproc, err = ResolveArchiveNames(preproc, lookup)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FileMetadata, 0, len(preproc))
+	for _, file := range preproc {
+		if IsProcessed(file) {
+			continue
 		}
-		if appendResp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(appendResp.Body)
-			_ = appendResp.Body.Close()
-			return newAPIError("/2/files/upload_session/append_v2", appendResp, body)
+		_, exists, err := lookup(RenameFile(file))
+		if err != nil {
+			return nil, err
 		}
-		if err := appendResp.Body.Close(); err != nil {
-			return err
+		if !exists {
+			result = append(result, file)
 		}
-
-		offset += int64(read)
 	}
+	return result, nil
+}
 
-	return nil
+func (c *Client) listSources(ctx context.Context, preprocessPaths []string) ([]FileMetadata, error) {
+	var files []FileMetadata
+	for _, path := range preprocessPaths {
+		listed, err := c.ListFilesContext(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", path, err)
+		}
+		files = append(files, listed...)
+	}
+	return ResolveSources(files), nil
 }
 
-func (c *Client) ListFilesToProcess(preprocessPath, archivePath string) ([]FileMetadata, error) {
-	preproc, err := c.ListFiles(preprocessPath)
+// MoveToProcessedContext moves a finished source into processedPath, renaming
+// it if a file with the same name is already there.
+func (c *Client) MoveToProcessedContext(ctx context.Context, file FileMetadata, processedPath string) (FileMetadata, error) {
+	payload, err := json.Marshal(map[string]any{
+		"from_path":  file.PathLower,
+		"to_path":    c.remotePath(processedPath, file.Name),
+		"autorename": true,
+	})
 	if err != nil {
-		return nil, err
+		return FileMetadata{}, err
 	}
-	archive, err := c.ListFiles(archivePath)
+	resp, err := c.doAPIRequest(ctx, "/2/files/move_v2", payload)
 	if err != nil {
-		return nil, err
+		return FileMetadata{}, err
 	}
-	archiveNames := make(map[string]struct{}, len(archive))
-	for _, file := range archive {
-		archiveNames[file.Name] = struct{}{}
+	var out struct {
+		Metadata fileEntry `json:"metadata"`
 	}
-	result := make([]FileMetadata, 0, len(preproc))
-	for _, file := range preproc {
-		if _, exists := archiveNames[c.RenameFile(file)]; !exists {
-			result = append(result, file)
-		}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return FileMetadata{}, err
 	}
-	return result, nil
+	return out.Metadata.metadata(), nil
 }
 
 func (c *Client) remotePath(base, name string) string {
@@ -389,8 +633,8 @@ func (c *Client) remotePath(base, name string) string {
 	return base + "/" + name
 }
 
-func (c *Client) doAPIRequest(endpoint string, payload []byte) ([]byte, error) {
-	resp, err := c.doAPIRequestWithJSONBody(endpoint, payload)
+func (c *Client) doAPIRequest(ctx context.Context, endpoint string, payload []byte) ([]byte, error) {
+	resp, err := c.doAPIRequestWithJSONBody(ctx, endpoint, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -404,13 +648,10 @@ func (c *Client) doAPIRequest(endpoint string, payload []byte) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
-func (c *Client) doAPIRequestNoBody(endpoint string) ([]byte, error) {
-	req, err := http.NewRequest("POST", apiHost+endpoint, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-	resp, err := c.client.Do(req)
+func (c *Client) doAPIRequestNoBody(ctx context.Context, endpoint string) ([]byte, error) {
+	resp, err := c.do(ctx, c.apiTimeout, false, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", c.apiURL(endpoint), nil)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -424,33 +665,201 @@ func (c *Client) doAPIRequestNoBody(endpoint string) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
-func (c *Client) doContentRequest(endpoint string, arg []byte, body []byte) (*http.Response, error) {
+func (c *Client) doContentRequest(ctx context.Context, endpoint string, arg []byte, body []byte) (*http.Response, error) {
+	return c.doContentRequestWithHeader(ctx, endpoint, arg, body, nil)
+}
+
+func (c *Client) doContentRequestWithHeader(ctx context.Context, endpoint string, arg []byte, body []byte, header http.Header) (*http.Response, error) {
 	if body == nil {
 		body = []byte{}
 	}
-	req, err := http.NewRequest("POST", contentHost+endpoint, bytes.NewReader(body))
+	resp, err := c.do(ctx, c.transferTimeout, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.contentURL(endpoint), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return c.limiter.reader(ctx, io.NopCloser(bytes.NewReader(body)), uploadTraffic), nil
+		}
+		req.Body, _ = req.GetBody()
+		if arg != nil {
+			req.Header.Set("Dropbox-API-Arg", string(arg))
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		for key, values := range header {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-	if arg != nil {
-		req.Header.Set("Dropbox-API-Arg", string(arg))
+	resp.Body = c.limiter.reader(ctx, resp.Body, downloadTraffic)
+	return resp, nil
+}
+
+func (c *Client) doAPIRequestWithJSONBody(ctx context.Context, endpoint string, payload []byte) (*http.Response, error) {
+	return c.do(ctx, c.apiTimeout, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL(endpoint), bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mime.TypeByExtension(".json"))
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	})
+}
+
+// do sends an authenticated request built by newRequest. If Dropbox rejects
+// the access token as expired or invalid, the token is refreshed and the
+// request is rebuilt and replayed once. A non-zero timeout bounds each
+// attempt, including reading the response body. With idle set it only
+// bounds the time without progress, so a long transfer that keeps moving,
+// however slowly, is never cut off.
+func (c *Client) do(ctx context.Context, timeout time.Duration, idle bool, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		reqCtx, cancel := ctx, context.CancelFunc(func() {})
+		var watchdog *idleWatchdog
+		switch {
+		case timeout > 0 && idle:
+			watchdog = newIdleWatchdog(ctx, timeout)
+			reqCtx, cancel = watchdog.ctx, watchdog.stop
+		case timeout > 0:
+			reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		req, err := newRequest(reqCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if watchdog != nil {
+			watchdog.watchRequest(req)
+		}
+		token := c.currentToken()
+		req.Header.Set("Authorization", "Bearer "+token)
+		if c.pathRoot != "" {
+			req.Header.Set("Dropbox-API-Path-Root", c.pathRoot)
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			err = watchdog.explain(err)
+			cancel()
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			if watchdog != nil {
+				resp.Body = watchdog.reader(resp.Body)
+			}
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		cancel()
+		if !isTokenError(body) {
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		}
+		if err := c.refreshExpiredToken(ctx, token); err != nil {
+			return nil, err
+		}
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	return c.client.Do(req)
 }
 
-func (c *Client) doAPIRequestWithJSONBody(endpoint string, payload []byte) (*http.Response, error) {
-	req, err := http.NewRequest("POST", apiHost+endpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, err
+// cancelOnClose releases a request's timeout once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// idleWatchdog ends a transfer's context once no bytes have moved for
+// timeout. Every read of the request or response body counts as progress.
+type idleWatchdog struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleWatchdog(ctx context.Context, timeout time.Duration) *idleWatchdog {
+	w := &idleWatchdog{timeout: timeout}
+	w.ctx, w.cancel = context.WithCancelCause(ctx)
+	// The cause wraps DeadlineExceeded so a stalled transfer is retried
+	// like any other timeout.
+	w.timer = time.AfterFunc(timeout, func() {
+		w.cancel(fmt.Errorf("no transfer progress for %s: %w", timeout, context.DeadlineExceeded))
+	})
+	return w
+}
+
+func (w *idleWatchdog) stop() {
+	w.timer.Stop()
+	w.cancel(context.Canceled)
+}
+
+// explain replaces the error of a request the watchdog ended with the
+// reason it did.
+func (w *idleWatchdog) explain(err error) error {
+	if w == nil || err == nil || err == io.EOF || w.ctx.Err() == nil {
+		return err
+	}
+	if cause := context.Cause(w.ctx); errors.Is(cause, context.DeadlineExceeded) {
+		return cause
 	}
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-	req.Header.Set("Content-Type", mime.TypeByExtension(".json"))
-	if req.Header.Get("Content-Type") == "" {
-		req.Header.Set("Content-Type", "application/json")
+	return err
+}
+
+func (w *idleWatchdog) watchRequest(req *http.Request) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = w.reader(req.Body)
+	}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return w.reader(body), nil
+		}
+	}
+}
+
+func (w *idleWatchdog) reader(body io.ReadCloser) io.ReadCloser {
+	return &progressReader{ReadCloser: body, watchdog: w}
+}
+
+// progressReader restarts its watchdog's timer whenever bytes are read.
+type progressReader struct {
+	io.ReadCloser
+	watchdog *idleWatchdog
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.watchdog.timer.Reset(r.watchdog.timeout)
+	}
+	return n, r.watchdog.explain(err)
+}
+
+func isTokenError(body []byte) bool {
+	_, tags := parseErrorBody(body)
+	for _, tag := range tags {
+		if errorTags[tag] == ErrInvalidAccessToken {
+			return true
+		}
 	}
-	return c.client.Do(req)
+	return false
 }
 
 func minInt64(a, b int64) int64 {
@@ -1,10 +1,15 @@
 package dropbox
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -54,3 +59,337 @@ func TestRefreshAccessTokenReturnsAPIErrorFor429(t *testing.T) {
 		t.Fatal("expected 429 error to be retryable")
 	}
 }
+
+func TestDownloadFileResumesFromPartial(t *testing.T) {
+	const content = "0123456789"
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "im-show.mp3")
+	if err := os.WriteFile(localPath+partSuffix, []byte(content[:4]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if got := req.Header.Get("Range"); got != "bytes=4-" {
+					t.Fatalf("expected Range bytes=4-, got %q", got)
+				}
+				return &http.Response{
+					StatusCode: http.StatusPartialContent,
+					Header:     http.Header{"Dropbox-Api-Result": []string{`{"size":10}`}},
+					Body:       io.NopCloser(strings.NewReader(content[4:])),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	if err := c.DownloadFileContext(context.Background(), localPath, FileMetadata{PathLower: "/live/show.mp3", Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("expected %q, got %q", content, got)
+	}
+	if _, err := os.Stat(localPath + partSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be renamed away, got %v", err)
+	}
+}
+
+func TestDownloadFileKeepsPartialOnShortBody(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "im-show.mp3")
+
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader("01234")),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	err := c.DownloadFileContext(context.Background(), localPath, FileMetadata{PathLower: "/live/show.mp3", Size: 10})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no final file, got %v", err)
+	}
+	info, err := os.Stat(localPath + partSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 {
+		t.Fatalf("expected 5 partial bytes, got %d", info.Size())
+	}
+}
+
+func TestDownloadFileRejectsContentHashMismatch(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "im-show.mp3")
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader("corrupt!!!")),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	err := c.DownloadFileContext(context.Background(), localPath, FileMetadata{PathLower: "/live/show.mp3", Size: 10, ContentHash: "deadbeef"})
+	if !errors.Is(err, ErrContentHashMismatch) {
+		t.Fatalf("expected ErrContentHashMismatch, got %v", err)
+	}
+	if _, err := os.Stat(localPath + partSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupt partial to be removed, got %v", err)
+	}
+}
+
+func TestExpiredTokenIsRefreshedOnceAndReplayed(t *testing.T) {
+	var refreshes atomic.Int32
+	c := &Client{
+		appKey:       "key",
+		appSecret:    "secret",
+		refreshToken: "refresh",
+		accessToken:  "stale",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				status, body := http.StatusOK, `{"account_id":"dbid:1"}`
+				switch {
+				case req.URL.Path == "/oauth2/token":
+					refreshes.Add(1)
+					body = `{"access_token":"fresh"}`
+				case req.Header.Get("Authorization") != "Bearer fresh":
+					status = http.StatusUnauthorized
+					body = `{"error_summary":"expired_access_token/","error":{".tag":"expired_access_token"}}`
+				}
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetCurrentAccount(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := refreshes.Load(); got != 1 {
+		t.Fatalf("expected a single token refresh, got %d", got)
+	}
+}
+
+func TestUnauthorizedWithoutTokenErrorIsNotReplayed(t *testing.T) {
+	calls := 0
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return &http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"error":{".tag":"missing_scope"}}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	_, err := c.GetCurrentAccount()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 APIError, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one request, got %d", calls)
+	}
+}
+
+func TestRefreshAccessTokenWithoutSecretSendsClientID(t *testing.T) {
+	c := &Client{
+		appKey:       "key",
+		refreshToken: "refresh",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if _, _, ok := req.BasicAuth(); ok {
+					t.Fatal("expected no basic auth without an app secret")
+				}
+				if err := req.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				if req.PostForm.Get("client_id") != "key" {
+					t.Fatalf("expected client_id in form, got %v", req.PostForm)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"access_token":"fresh"}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	if err := c.refreshAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+	if c.accessToken != "fresh" {
+		t.Fatalf("expected fresh token, got %q", c.accessToken)
+	}
+}
+
+func TestScopesComeFromTheLatestTokenResponse(t *testing.T) {
+	scope := "account_info.read files.content.read"
+	c := &Client{
+		appKey:       "key",
+		appSecret:    "secret",
+		refreshToken: "refresh",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"access_token":"fresh","scope":"` + scope + `"}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	if got := c.Scopes(); len(got) != 0 {
+		t.Fatalf("expected no scopes before the first refresh, got %v", got)
+	}
+	if err := c.refreshAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+	scopes := c.Scopes()
+	if strings.Join(scopes, " ") != scope {
+		t.Fatalf("expected the granted scopes, got %v", scopes)
+	}
+	scopes[0] = "changed"
+	if c.Scopes()[0] != "account_info.read" {
+		t.Fatal("expected Scopes to return a copy")
+	}
+
+	scope = "files.content.read"
+	if err := c.refreshAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.Scopes(), " "); got != scope {
+		t.Fatalf("expected the scopes of the new token, got %q", got)
+	}
+}
+
+func TestRequestTimeoutCancelsHungRequest(t *testing.T) {
+	c := &Client{
+		accessToken: "token",
+		apiTimeout:  20 * time.Millisecond,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}),
+		},
+	}
+	_, err := c.ListFilesContext(context.Background(), "/automation")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to time out, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.apiTimeout = 0
+	if _, err := c.GetMetadataContext(ctx, "/automation/show.mp3"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled request, got %v", err)
+	}
+}
+
+// trickleReader returns one byte per read after waiting delay, and blocks
+// for good once it runs out of bytes when stall is set.
+type trickleReader struct {
+	ctx   context.Context
+	data  string
+	delay time.Duration
+	stall bool
+}
+
+func (r *trickleReader) Read(p []byte) (int, error) {
+	if r.data == "" && !r.stall {
+		return 0, io.EOF
+	}
+	wait := r.delay
+	if r.data == "" {
+		wait = time.Hour
+	}
+	select {
+	case <-time.After(wait):
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+	n := copy(p, r.data[:1])
+	r.data = r.data[1:]
+	return n, nil
+}
+
+func TestTransferTimeoutOnlyCutsOffStalledTransfers(t *testing.T) {
+	stall := false
+	c := &Client{
+		accessToken:     "token",
+		transferTimeout: 80 * time.Millisecond,
+		limiter:         newLimiter(RateLimits{}),
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(&trickleReader{ctx: req.Context(), data: "abcdef", delay: 30 * time.Millisecond, stall: stall}),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	// Slow but steady: the whole download takes longer than the timeout.
+	resp, err := c.doContentRequest(context.Background(), "/2/files/download", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || string(got) != "abcdef" {
+		t.Fatalf("expected the slow download to finish, got %q, %v", got, err)
+	}
+
+	stall = true
+	resp, err = c.doContentRequest(context.Background(), "/2/files/download", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !errors.Is(err, context.DeadlineExceeded) || string(got) != "abcdef" {
+		t.Fatalf("expected the stalled download to time out after its data, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,153 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// WritePolicy decides what happens when an upload or archive copy targets a
+// path that already exists.
+type WritePolicy string
+
+const (
+	// PolicySkipIfIdentical keeps the existing file when its content_hash
+	// matches and fails with ErrConflict otherwise. It is the default.
+	PolicySkipIfIdentical WritePolicy = "skip-if-identical"
+	// PolicyOverwrite replaces the existing file.
+	PolicyOverwrite WritePolicy = "overwrite"
+	// PolicyUpdateByRev replaces the existing file only if it is still at the
+	// rev rbv looked up, so concurrent edits surface as ErrConflict.
+	PolicyUpdateByRev WritePolicy = "update-by-rev"
+	// PolicyAutorename writes next to the existing file under a new name.
+	PolicyAutorename WritePolicy = "autorename"
+)
+
+// writeCommit is Dropbox's CommitInfo for uploads.
+type writeCommit struct {
+	Path       string `json:"path"`
+	Mode       any    `json:"mode"`
+	Autorename bool   `json:"autorename"`
+	Mute       bool   `json:"mute"`
+}
+
+func (c *Client) GetMetadataContext(ctx context.Context, path string) (FileMetadata, error) {
+	payload, err := json.Marshal(map[string]string{"path": path})
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/files/get_metadata", payload)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	var entry fileEntry
+	if err := json.Unmarshal(resp, &entry); err != nil {
+		return FileMetadata{}, err
+	}
+	return entry.metadata(), nil
+}
+
+// uploadCommit builds the commit for uploading a file with localHash to
+// remotePath under policy. When the upload can be skipped it returns the
+// existing file instead.
+func (c *Client) uploadCommit(ctx context.Context, remotePath, localHash string, policy WritePolicy) (writeCommit, *FileMetadata, error) {
+	commit := writeCommit{Path: remotePath, Mode: "add"}
+	switch policy {
+	case PolicyOverwrite:
+		commit.Mode = "overwrite"
+		return commit, nil, nil
+	case PolicyAutorename:
+		commit.Autorename = true
+		return commit, nil, nil
+	case PolicyUpdateByRev, PolicySkipIfIdentical, "":
+	default:
+		return writeCommit{}, nil, fmt.Errorf("unknown write policy %q", policy)
+	}
+
+	existing, err := c.GetMetadataContext(ctx, remotePath)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return commit, nil, nil
+	case err != nil:
+		return writeCommit{}, nil, err
+	case policy == PolicyUpdateByRev:
+		commit.Mode = map[string]string{".tag": "update", "update": existing.Rev}
+		return commit, nil, nil
+	case existing.ContentHash == localHash:
+		return commit, &existing, nil
+	default:
+		return writeCommit{}, nil, fmt.Errorf("%w: %s already exists with different content", ErrConflict, remotePath)
+	}
+}
+
+// CopyToArchiveContext copies soundcloudPath/name into archivePath, resolving
+// an existing archive file according to policy.
+func (c *Client) CopyToArchiveContext(ctx context.Context, name, soundcloudPath, archivePath string, policy WritePolicy) (FileMetadata, error) {
+	fromPath := c.remotePath(soundcloudPath, name)
+	toPath := c.remotePath(archivePath, name)
+	switch policy {
+	case PolicySkipIfIdentical, PolicyOverwrite, PolicyUpdateByRev, PolicyAutorename, "":
+	default:
+		return FileMetadata{}, fmt.Errorf("unknown write policy %q", policy)
+	}
+
+	copied, err := c.copyFile(ctx, fromPath, toPath, policy == PolicyAutorename)
+	if !errors.Is(err, ErrConflict) || policy == PolicyAutorename {
+		return copied, err
+	}
+	return c.resolveCopyConflict(ctx, fromPath, toPath, policy)
+}
+
+// resolveCopyConflict finishes a copy of fromPath that failed because
+// toPath already exists.
+func (c *Client) resolveCopyConflict(ctx context.Context, fromPath, toPath string, policy WritePolicy) (FileMetadata, error) {
+	existing, err := c.GetMetadataContext(ctx, toPath)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	switch policy {
+	case PolicyOverwrite:
+		if err := c.deleteFile(ctx, toPath, ""); err != nil {
+			return FileMetadata{}, err
+		}
+	case PolicyUpdateByRev:
+		// delete_v2 with parent_rev fails if the archive copy changed since
+		// we looked it up.
+		if err := c.deleteFile(ctx, toPath, existing.Rev); err != nil {
+			return FileMetadata{}, err
+		}
+	default:
+		source, err := c.GetMetadataContext(ctx, fromPath)
+		if err != nil {
+			return FileMetadata{}, err
+		}
+		if source.ContentHash != existing.ContentHash {
+			return FileMetadata{}, fmt.Errorf("%w: %s already exists with different content", ErrConflict, toPath)
+		}
+		return existing, nil
+	}
+	return c.copyFile(ctx, fromPath, toPath, false)
+}
+
+func (c *Client) copyFile(ctx context.Context, fromPath, toPath string, autorename bool) (FileMetadata, error) {
+	payload, err := json.Marshal(map[string]any{
+		"from_path":  fromPath,
+		"to_path":    toPath,
+		"autorename": autorename,
+	})
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/files/copy_v2", payload)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	var out struct {
+		Metadata fileEntry `json:"metadata"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return FileMetadata{}, err
+	}
+	return out.Metadata.metadata(), nil
+}
@@ -0,0 +1,48 @@
+package dropbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+const hashBlockSize = 4 * 1024 * 1024
+
+// ErrContentHashMismatch means a transfer completed but the bytes on one side
+// differ from the other. The transfer is safe to retry from scratch.
+var ErrContentHashMismatch = errors.New("dropbox content hash mismatch")
+
+// ContentHash computes Dropbox's content_hash: the SHA-256 of the
+// concatenated SHA-256 digests of each 4 MiB block, hex encoded.
+// See https://www.dropbox.com/developers/reference/content-hash.
+func ContentHash(r io.Reader) (string, error) {
+	overall := sha256.New()
+	block := make([]byte, hashBlockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			sum := sha256.Sum256(block[:n])
+			overall.Write(sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(overall.Sum(nil)), nil
+}
+
+func FileContentHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return ContentHash(file)
+}
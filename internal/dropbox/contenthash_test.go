@@ -0,0 +1,34 @@
+package dropbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestContentHashHashesBlockDigests(t *testing.T) {
+	data := bytes.Repeat([]byte{0x5a}, hashBlockSize+10)
+	first := sha256.Sum256(data[:hashBlockSize])
+	second := sha256.Sum256(data[hashBlockSize:])
+	want := sha256.Sum256(append(first[:], second[:]...))
+
+	got, err := ContentHash(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != hex.EncodeToString(want[:]) {
+		t.Fatalf("expected %x, got %s", want, got)
+	}
+}
+
+func TestContentHashEmpty(t *testing.T) {
+	got, err := ContentHash(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(nil)
+	if got != hex.EncodeToString(want[:]) {
+		t.Fatalf("expected %x, got %s", want, got)
+	}
+}
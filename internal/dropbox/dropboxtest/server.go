@@ -0,0 +1,595 @@
+// Package dropboxtest provides an in-memory fake of the Dropbox HTTP API for
+// tests. Point a client at it with dropbox.WithBaseURL(server.URL).
+//
+// It implements the endpoints rbv uses: oauth2/token, list_folder,
+// get_metadata, download (with Range), upload and upload sessions, copy_v2,
+// copy_batch_v2, move_v2, delete_v2 and get_current_account. Batch copies
+// complete immediately.
+package dropboxtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+)
+
+const (
+	// RefreshToken is the only refresh token the server accepts.
+	RefreshToken = "fake-refresh-token"
+	// AccessToken is issued for RefreshToken and required on API requests.
+	AccessToken = "fake-access-token"
+	// AuthCode is the only authorization code the server exchanges.
+	AuthCode = "fake-auth-code"
+)
+
+type file struct {
+	id       string
+	path     string
+	content  []byte
+	modified time.Time
+	rev      string
+}
+
+type session struct {
+	content []byte
+	closed  bool
+}
+
+// Server is a fake Dropbox API backed by an in-memory file tree. Paths are
+// case-insensitive, as in Dropbox.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]*file
+	folders  map[string]bool
+	sessions map[string]*session
+	calls    map[string]int
+	nextRev  int
+}
+
+// NewServer starts a fake with an empty root folder. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		files:    map[string]*file{},
+		folders:  map[string]bool{"": true},
+		sessions: map[string]*session{},
+		calls:    map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// PutFile stores content at p, creating parent folders.
+func (s *Server) PutFile(p string, content []byte, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(p, content, modified)
+}
+
+// Mkdir creates the folder p and its parents.
+func (s *Server) Mkdir(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mkdirAll(strings.ToLower(p))
+}
+
+// File returns the content stored at p.
+func (s *Server) File(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[strings.ToLower(p)]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(f.content), true
+}
+
+// Files lists the paths of all stored files, sorted.
+func (s *Server) Files() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.files))
+	for _, f := range s.files {
+		paths = append(paths, f.path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Calls reports how many requests endpoint has received, e.g.
+// Calls("/2/files/copy_batch_v2").
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+func (s *Server) put(p string, content []byte, modified time.Time) *file {
+	s.nextRev++
+	f := &file{
+		id:       fmt.Sprintf("id:%09x", s.nextRev),
+		path:     p,
+		content:  bytes.Clone(content),
+		modified: modified.UTC().Truncate(time.Second),
+		rev:      fmt.Sprintf("%09x", s.nextRev),
+	}
+	key := strings.ToLower(p)
+	s.files[key] = f
+	s.mkdirAll(path.Dir(key))
+	return f
+}
+
+func (s *Server) mkdirAll(dir string) {
+	for dir != "/" && dir != "." && dir != "" {
+		s.folders[dir] = true
+		dir = path.Dir(dir)
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[r.URL.Path]++
+
+	if r.URL.Path == "/oauth2/token" {
+		s.token(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+		writeError(w, http.StatusUnauthorized, "invalid_access_token")
+		return
+	}
+
+	arg := []byte(r.Header.Get("Dropbox-API-Arg"))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(arg) == 0 {
+		arg = body
+	}
+
+	switch r.URL.Path {
+	case "/2/users/get_current_account":
+		writeJSON(w, map[string]any{
+			"account_id": "dbid:fake",
+			"email":      "rbv@example.com",
+			"name":       map[string]string{"display_name": "Radio Buena Vida"},
+			"root_info":  map[string]string{".tag": "user", "root_namespace_id": "1", "home_namespace_id": "1"},
+		})
+	case "/2/files/list_folder":
+		s.listFolder(w, arg)
+	case "/2/files/get_metadata":
+		s.getMetadata(w, arg)
+	case "/2/files/download":
+		s.download(w, r, arg)
+	case "/2/files/upload":
+		s.upload(w, arg, body)
+	case "/2/files/upload_session/start":
+		s.startSession(w, arg, body)
+	case "/2/files/upload_session/append_v2":
+		s.appendSession(w, arg, body)
+	case "/2/files/upload_session/finish":
+		s.finishSession(w, arg, body)
+	case "/2/files/copy_v2":
+		s.relocate(w, arg, false)
+	case "/2/files/move_v2":
+		s.relocate(w, arg, true)
+	case "/2/files/copy_batch_v2":
+		s.copyBatch(w, arg)
+	case "/2/files/delete_v2":
+		s.delete(w, arg)
+	default:
+		http.Error(w, "dropboxtest: unsupported endpoint "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != RefreshToken {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token": AccessToken,
+			"token_type":   "bearer",
+			"expires_in":   14400,
+			"scope":        "files.content.read files.content.write",
+		})
+	case "authorization_code":
+		if r.PostForm.Get("code") != AuthCode {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token":  AccessToken,
+			"refresh_token": RefreshToken,
+			"token_type":    "bearer",
+		})
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+	}
+}
+
+func (s *Server) listFolder(w http.ResponseWriter, arg []byte) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	dir := strings.TrimSuffix(strings.ToLower(in.Path), "/")
+	if !s.folders[dir] {
+		writeError(w, http.StatusConflict, "path", "not_found")
+		return
+	}
+	parent := dir
+	if parent == "" {
+		parent = "/"
+	}
+	// Real listings come back in a stable order; map order would make tests
+	// that depend on it flaky.
+	keys := make([]string, 0, len(s.files))
+	for key := range s.files {
+		if path.Dir(key) == parent {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	entries := []any{}
+	for _, key := range keys {
+		entries = append(entries, entry(s.files[key]))
+	}
+	for key := range s.folders {
+		if key != "" && path.Dir(key) == parent {
+			entries = append(entries, map[string]string{".tag": "folder", "name": path.Base(key), "path_lower": key})
+		}
+	}
+	writeJSON(w, map[string]any{"entries": entries, "cursor": "fake-cursor", "has_more": false})
+}
+
+func (s *Server) getMetadata(w http.ResponseWriter, arg []byte) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	key := strings.ToLower(in.Path)
+	if f, ok := s.files[key]; ok {
+		writeJSON(w, entry(f))
+		return
+	}
+	if s.folders[key] {
+		writeJSON(w, map[string]string{".tag": "folder", "name": path.Base(key), "path_lower": key})
+		return
+	}
+	writeError(w, http.StatusConflict, "path", "not_found")
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request, arg []byte) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	f, ok := s.files[strings.ToLower(in.Path)]
+	if !ok {
+		writeError(w, http.StatusConflict, "path", "not_found")
+		return
+	}
+	result, _ := json.Marshal(entry(f))
+	w.Header().Set("Dropbox-API-Result", string(result))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	content := f.content
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if err != nil || start >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		content = content[start:]
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
+
+type commitInfo struct {
+	Path           string          `json:"path"`
+	Mode           json.RawMessage `json:"mode"`
+	Autorename     bool            `json:"autorename"`
+	ClientModified string          `json:"client_modified"`
+}
+
+func (s *Server) upload(w http.ResponseWriter, arg, body []byte) {
+	var commit commitInfo
+	if !decode(w, arg, &commit) {
+		return
+	}
+	s.commit(w, commit, body)
+}
+
+func (s *Server) commit(w http.ResponseWriter, commit commitInfo, content []byte) {
+	target := commit.Path
+	existing, ok := s.files[strings.ToLower(target)]
+	if ok && bytes.Equal(existing.content, content) {
+		// Dropbox treats re-uploading identical content as a no-op.
+		writeJSON(w, entry(existing))
+		return
+	}
+	if ok {
+		var mode struct {
+			Tag    string `json:".tag"`
+			Update string `json:"update"`
+		}
+		if err := json.Unmarshal(commit.Mode, &mode.Tag); err != nil {
+			_ = json.Unmarshal(commit.Mode, &mode)
+		}
+		switch {
+		case mode.Tag == "overwrite":
+		case mode.Tag == "update" && mode.Update == existing.rev:
+		case commit.Autorename:
+			target = s.freePath(target)
+		default:
+			writeError(w, http.StatusConflict, "path", "conflict", "file")
+			return
+		}
+	}
+	modified := time.Now()
+	if parsed, err := time.Parse(time.RFC3339, commit.ClientModified); err == nil {
+		modified = parsed
+	}
+	writeJSON(w, entry(s.put(target, content, modified)))
+}
+
+func (s *Server) startSession(w http.ResponseWriter, arg, body []byte) {
+	var in struct {
+		Close bool `json:"close"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	id := fmt.Sprintf("session-%d", len(s.sessions)+1)
+	s.sessions[id] = &session{content: bytes.Clone(body), closed: in.Close}
+	writeJSON(w, map[string]string{"session_id": id})
+}
+
+type sessionCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int    `json:"offset"`
+}
+
+func (s *Server) lookupSession(w http.ResponseWriter, cursor sessionCursor, wrap string) (*session, bool) {
+	sess, ok := s.sessions[cursor.SessionID]
+	if !ok {
+		if wrap == "" {
+			writeError(w, http.StatusConflict, "not_found")
+		} else {
+			writeError(w, http.StatusConflict, wrap, "not_found")
+		}
+		return nil, false
+	}
+	if cursor.Offset != len(sess.content) {
+		inner := map[string]any{".tag": "incorrect_offset", "correct_offset": len(sess.content)}
+		errBody := inner
+		if wrap != "" {
+			errBody = map[string]any{".tag": wrap, wrap: inner}
+		}
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error_summary": strings.TrimPrefix(wrap+"/incorrect_offset/..", "/"),
+			"error":         errBody,
+		})
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *Server) appendSession(w http.ResponseWriter, arg, body []byte) {
+	var in struct {
+		Cursor sessionCursor `json:"cursor"`
+		Close  bool          `json:"close"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	sess, ok := s.lookupSession(w, in.Cursor, "")
+	if !ok {
+		return
+	}
+	if sess.closed {
+		writeError(w, http.StatusConflict, "closed")
+		return
+	}
+	sess.content = append(sess.content, body...)
+	sess.closed = in.Close
+	writeJSON(w, nil)
+}
+
+func (s *Server) finishSession(w http.ResponseWriter, arg, body []byte) {
+	var in struct {
+		Cursor sessionCursor `json:"cursor"`
+		Commit commitInfo    `json:"commit"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	sess, ok := s.lookupSession(w, in.Cursor, "lookup_failed")
+	if !ok {
+		return
+	}
+	delete(s.sessions, in.Cursor.SessionID)
+	s.commit(w, in.Commit, append(sess.content, body...))
+}
+
+type relocation struct {
+	FromPath   string `json:"from_path"`
+	ToPath     string `json:"to_path"`
+	Autorename bool   `json:"autorename"`
+}
+
+func (s *Server) relocate(w http.ResponseWriter, arg []byte, move bool) {
+	var in relocation
+	if !decode(w, arg, &in) {
+		return
+	}
+	copied, tags := s.copy(in, move)
+	if tags != nil {
+		writeError(w, http.StatusConflict, tags...)
+		return
+	}
+	writeJSON(w, map[string]any{"metadata": entry(copied)})
+}
+
+// copy copies or moves one file and returns the error tags on failure.
+func (s *Server) copy(in relocation, move bool) (*file, []string) {
+	src, ok := s.files[strings.ToLower(in.FromPath)]
+	if !ok {
+		return nil, []string{"from_lookup", "not_found"}
+	}
+	target := in.ToPath
+	if _, exists := s.files[strings.ToLower(target)]; exists {
+		if !in.Autorename {
+			return nil, []string{"to", "conflict", "file"}
+		}
+		target = s.freePath(target)
+	}
+	copied := s.put(target, src.content, src.modified)
+	if move {
+		// Moved files keep their id.
+		copied.id = src.id
+		delete(s.files, strings.ToLower(in.FromPath))
+	}
+	return copied, nil
+}
+
+func (s *Server) copyBatch(w http.ResponseWriter, arg []byte) {
+	var in struct {
+		Entries    []relocation `json:"entries"`
+		Autorename bool         `json:"autorename"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	results := make([]any, 0, len(in.Entries))
+	for _, reloc := range in.Entries {
+		reloc.Autorename = in.Autorename
+		copied, tags := s.copy(reloc, false)
+		if tags != nil {
+			results = append(results, map[string]any{
+				".tag":    "failure",
+				"failure": nestTags(append([]string{"relocation_error"}, tags...)),
+			})
+			continue
+		}
+		results = append(results, map[string]any{".tag": "success", "success": entry(copied)})
+	}
+	writeJSON(w, map[string]any{".tag": "complete", "entries": results})
+}
+
+func (s *Server) delete(w http.ResponseWriter, arg []byte) {
+	var in struct {
+		Path      string `json:"path"`
+		ParentRev string `json:"parent_rev"`
+	}
+	if !decode(w, arg, &in) {
+		return
+	}
+	key := strings.ToLower(in.Path)
+	f, ok := s.files[key]
+	if !ok {
+		writeError(w, http.StatusConflict, "path_lookup", "not_found")
+		return
+	}
+	if in.ParentRev != "" && in.ParentRev != f.rev {
+		writeError(w, http.StatusConflict, "path_write", "conflict", "file")
+		return
+	}
+	delete(s.files, key)
+	writeJSON(w, map[string]any{"metadata": entry(f)})
+}
+
+// freePath picks "name (n).ext" next to p, the way Dropbox autorenames.
+func (s *Server) freePath(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, exists := s.files[strings.ToLower(candidate)]; !exists {
+			return candidate
+		}
+	}
+}
+
+func entry(f *file) map[string]any {
+	hash, _ := dropbox.ContentHash(bytes.NewReader(f.content))
+	return map[string]any{
+		".tag":            "file",
+		"id":              f.id,
+		"name":            path.Base(f.path),
+		"path_lower":      strings.ToLower(f.path),
+		"path_display":    f.path,
+		"client_modified": f.modified.Format(time.RFC3339),
+		"server_modified": f.modified.Format(time.RFC3339),
+		"rev":             f.rev,
+		"size":            len(f.content),
+		"content_hash":    hash,
+	}
+}
+
+func nestTags(tags []string) map[string]any {
+	inner := map[string]any{".tag": tags[len(tags)-1]}
+	for i := len(tags) - 2; i >= 0; i-- {
+		inner = map[string]any{".tag": tags[i], tags[i]: inner}
+	}
+	return inner
+}
+
+func decode(w http.ResponseWriter, arg []byte, out any) bool {
+	if err := json.Unmarshal(arg, out); err != nil {
+		http.Error(w, "dropboxtest: bad request argument: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, tags ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error_summary": strings.Join(tags, "/") + "/..",
+		"error":         nestTags(tags),
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,99 @@
+package dropboxtest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+	"radiobuenavia/internal/dropbox/dropboxtest"
+)
+
+func newClient(t *testing.T, srv *dropboxtest.Server) *dropbox.Client {
+	t.Helper()
+	c, err := dropbox.NewClient("key", "secret", dropboxtest.RefreshToken, dropbox.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestExchangeAuthCodeAgainstFake(t *testing.T) {
+	srv := dropboxtest.NewServer()
+	defer srv.Close()
+
+	token, err := dropbox.ExchangeAuthCode("key", "secret", dropboxtest.AuthCode, dropbox.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != dropboxtest.RefreshToken {
+		t.Fatalf("unexpected refresh token %q", token)
+	}
+	if _, err := dropbox.NewClient("key", "secret", "revoked", dropbox.WithBaseURL(srv.URL)); err == nil {
+		t.Fatal("expected an unknown refresh token to be rejected")
+	}
+}
+
+func TestSessionUploadDownloadAndCopy(t *testing.T) {
+	srv := dropboxtest.NewServer()
+	defer srv.Close()
+	srv.Mkdir("/archive")
+	c := newClient(t, srv)
+
+	content := bytes.Repeat([]byte("rbv"), 3*1024*1024)
+	localPath := filepath.Join(t.TempDir(), "ex-show.mp3")
+	if err := os.WriteFile(localPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := c.UploadFileSoundcloudContext(context.Background(), localPath, "show.mp3", "/post", dropbox.PolicySkipIfIdentical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Calls("/2/files/upload_session/start") != 1 || srv.Calls("/2/files/upload_session/finish") != 1 {
+		t.Fatal("expected a file over 4 MiB to use an upload session")
+	}
+	if got, _ := srv.File("/post/show.mp3"); !bytes.Equal(got, content) {
+		t.Fatal("uploaded content differs")
+	}
+
+	downloadPath := filepath.Join(t.TempDir(), "im-show.mp3")
+	if err := os.WriteFile(downloadPath+".part", content[:1024], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DownloadFileContext(context.Background(), downloadPath, uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(downloadPath); !bytes.Equal(got, content) {
+		t.Fatal("resumed download differs")
+	}
+
+	if _, err := c.CopyToArchiveContext(context.Background(), "show.mp3", "/post", "/archive", dropbox.PolicySkipIfIdentical); err != nil {
+		t.Fatal(err)
+	}
+	srv.PutFile("/post/other.mp3", []byte("different"), time.Now())
+	_, err = c.CopyToArchiveContext(context.Background(), "other.mp3", "/post", "/archive", dropbox.PolicySkipIfIdentical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.PutFile("/archive/clash.mp3", []byte("old"), time.Now())
+	srv.PutFile("/post/clash.mp3", []byte("new"), time.Now())
+	_, err = c.CopyToArchiveContext(context.Background(), "clash.mp3", "/post", "/archive", dropbox.PolicySkipIfIdentical)
+	if !errors.Is(err, dropbox.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	files, err := c.ListFilesContext(context.Background(), "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 archived files, got %+v", files)
+	}
+	if _, err := c.ListFilesContext(context.Background(), "/missing"); !errors.Is(err, dropbox.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing folder, got %v", err)
+	}
+}
@@ -1,13 +1,45 @@
 package dropbox
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// Sentinel errors matched by APIError.Is against the tags of a Dropbox error,
+// wherever they appear in the nested structure. For example both
+// path/not_found and from_lookup/not_found match ErrNotFound.
+var (
+	ErrNotFound           = errors.New("dropbox: not found")
+	ErrConflict           = errors.New("dropbox: conflict")
+	ErrInsufficientSpace  = errors.New("dropbox: insufficient space")
+	ErrMalformedPath      = errors.New("dropbox: malformed path")
+	ErrRateLimited        = errors.New("dropbox: rate limited")
+	ErrInvalidAccessToken = errors.New("dropbox: invalid access token")
+	ErrIncorrectOffset    = errors.New("dropbox: incorrect upload offset")
+	// ErrCursorReset means Dropbox invalidated a list_folder cursor and the
+	// folder has to be listed again from a fresh cursor.
+	ErrCursorReset = errors.New("dropbox: list_folder cursor was reset")
+)
+
+var errorTags = map[string]error{
+	"not_found":                 ErrNotFound,
+	"conflict":                  ErrConflict,
+	"insufficient_space":        ErrInsufficientSpace,
+	"malformed_path":            ErrMalformedPath,
+	"too_many_requests":         ErrRateLimited,
+	"too_many_write_operations": ErrRateLimited,
+	"expired_access_token":      ErrInvalidAccessToken,
+	"invalid_access_token":      ErrInvalidAccessToken,
+	"incorrect_offset":          ErrIncorrectOffset,
+	"reset":                     ErrCursorReset,
+}
+
 // APIError carries Dropbox HTTP metadata so callers can retry intelligently.
 type APIError struct {
 	Endpoint   string
@@ -15,6 +47,11 @@ type APIError struct {
 	StatusCode int
 	Body       string
 	RetryAfter time.Duration
+	// Summary is Dropbox's error_summary, e.g. "path/not_found/..".
+	Summary string
+	// Tags lists the nested .tag values from outermost to innermost, e.g.
+	// ["to", "conflict", "file"] for a copy onto an existing file.
+	Tags []string
 }
 
 func (e *APIError) Error() string {
@@ -27,11 +64,33 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("dropbox request failed: %s %s returned HTTP %d: %s", e.Method, e.Endpoint, e.StatusCode, e.Body)
 }
 
+func (e *APIError) Is(target error) bool {
+	if e == nil {
+		return false
+	}
+	if target == ErrRateLimited && e.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	for _, tag := range e.Tags {
+		if errorTags[tag] == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *APIError) HasTag(tag string) bool {
+	return e != nil && slices.Contains(e.Tags, tag)
+}
+
 func (e *APIError) Retryable() bool {
 	if e == nil {
 		return false
 	}
-	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+	if e.StatusCode >= http.StatusInternalServerError || e.HasTag("internal_error") {
+		return true
+	}
+	return errors.Is(e, ErrRateLimited)
 }
 
 func (e *APIError) RetryDelay() (time.Duration, bool) {
@@ -52,13 +111,52 @@ func newAPIError(endpoint string, resp *http.Response, body []byte) *APIError {
 		status = resp.StatusCode
 		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
 	}
+	summary, tags := parseErrorBody(body)
 	return &APIError{
 		Endpoint:   endpoint,
 		Method:     method,
 		StatusCode: status,
 		Body:       strings.TrimSpace(string(body)),
 		RetryAfter: retryAfter,
+		Summary:    summary,
+		Tags:       tags,
+	}
+}
+
+// parseErrorBody walks Dropbox's union encoding, where each level names its
+// variant in ".tag" and nests the variant's value under a key of that name.
+// Bodies without a structured error fall back to splitting error_summary.
+func parseErrorBody(body []byte) (string, []string) {
+	var payload struct {
+		Summary string          `json:"error_summary"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil
+	}
+	var tags []string
+	raw := payload.Error
+	for len(raw) > 0 {
+		var level map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &level); err != nil {
+			break
+		}
+		var tag string
+		if err := json.Unmarshal(level[".tag"], &tag); err != nil || tag == "" {
+			break
+		}
+		tags = append(tags, tag)
+		raw = level[tag]
+	}
+	if len(tags) == 0 {
+		for _, part := range strings.Split(payload.Summary, "/") {
+			part = strings.TrimRight(strings.TrimSpace(part), ".")
+			if part != "" {
+				tags = append(tags, part)
+			}
+		}
 	}
+	return payload.Summary, tags
 }
 
 func parseRetryAfter(raw string) time.Duration {
@@ -1,7 +1,9 @@
 package dropbox
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"testing"
 	"time"
 )
@@ -35,3 +37,42 @@ func TestAPIErrorRetryable(t *testing.T) {
 		t.Fatal("expected 4xx (non-429) to be non-retryable")
 	}
 }
+
+func TestNewAPIErrorParsesNestedTags(t *testing.T) {
+	body := []byte(`{"error_summary":"to/conflict/file/..","error":{".tag":"to","to":{".tag":"conflict","conflict":{".tag":"file"}}}}`)
+	apiErr := newAPIError("/2/files/copy_v2", &http.Response{StatusCode: http.StatusConflict, Header: http.Header{}}, body)
+
+	if apiErr.Summary != "to/conflict/file/.." {
+		t.Fatalf("unexpected summary %q", apiErr.Summary)
+	}
+	if !slices.Equal(apiErr.Tags, []string{"to", "conflict", "file"}) {
+		t.Fatalf("unexpected tags %v", apiErr.Tags)
+	}
+	if !errors.Is(apiErr, ErrConflict) {
+		t.Fatal("expected ErrConflict")
+	}
+	if errors.Is(apiErr, ErrNotFound) {
+		t.Fatal("did not expect ErrNotFound")
+	}
+	if apiErr.Retryable() {
+		t.Fatal("expected conflict to be non-retryable")
+	}
+}
+
+func TestNewAPIErrorFallsBackToSummary(t *testing.T) {
+	body := []byte(`{"error_summary":"path/not_found/.","error":"unexpected"}`)
+	apiErr := newAPIError("/2/files/list_folder", &http.Response{StatusCode: http.StatusConflict, Header: http.Header{}}, body)
+
+	if !errors.Is(apiErr, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from summary, got tags %v", apiErr.Tags)
+	}
+}
+
+func TestAPIErrorRetryableByKind(t *testing.T) {
+	body := []byte(`{"error_summary":"path/too_many_write_operations/","error":{".tag":"path","path":{".tag":"too_many_write_operations"}}}`)
+	apiErr := newAPIError("/2/files/upload", &http.Response{StatusCode: http.StatusConflict, Header: http.Header{}}, body)
+
+	if !errors.Is(apiErr, ErrRateLimited) || !apiErr.Retryable() {
+		t.Fatal("expected too_many_write_operations to be a retryable rate limit")
+	}
+}
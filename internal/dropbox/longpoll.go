@@ -0,0 +1,106 @@
+package dropbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const notifyHost = "https://notify.dropboxapi.com"
+
+// LatestCursorContext returns a cursor for path that reports only changes made
+// from now on.
+func (c *Client) LatestCursorContext(ctx context.Context, path string) (string, error) {
+	payload, err := json.Marshal(map[string]any{
+		"path":      path,
+		"recursive": false,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/files/list_folder/get_latest_cursor", payload)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Cursor string `json:"cursor"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return "", err
+	}
+	if out.Cursor == "" {
+		return "", errors.New("dropbox get_latest_cursor response missing cursor")
+	}
+	return out.Cursor, nil
+}
+
+// ListChangesContext returns the files added or modified since cursor along
+// with the cursor to use next time.
+func (c *Client) ListChangesContext(ctx context.Context, cursor string) ([]FileMetadata, string, error) {
+	var files []FileMetadata
+	for {
+		payload, err := json.Marshal(map[string]string{"cursor": cursor})
+		if err != nil {
+			return nil, "", err
+		}
+		resp, err := c.doAPIRequest(ctx, "/2/files/list_folder/continue", payload)
+		if err != nil {
+			return nil, "", err
+		}
+		var out struct {
+			Entries []fileEntry `json:"entries"`
+			Cursor  string      `json:"cursor"`
+			HasMore bool        `json:"has_more"`
+		}
+		if err := json.Unmarshal(resp, &out); err != nil {
+			return nil, "", err
+		}
+		files = append(files, extractFiles(out.Entries)...)
+		cursor = out.Cursor
+		if !out.HasMore {
+			return files, cursor, nil
+		}
+	}
+}
+
+// LongpollContext blocks until the folder behind cursor changes or timeout
+// passes. Dropbox accepts timeouts between 30s and 480s. The returned backoff
+// is how long Dropbox asks callers to wait before polling again.
+func (c *Client) LongpollContext(ctx context.Context, cursor string, timeout time.Duration) (bool, time.Duration, error) {
+	payload, err := json.Marshal(map[string]any{
+		"cursor":  cursor,
+		"timeout": int(timeout / time.Second),
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	// The notify endpoint is unauthenticated; the cursor identifies the folder.
+	req, err := http.NewRequestWithContext(ctx, "POST", c.notifyURL("/2/files/list_folder/longpoll"), bytes.NewReader(payload))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.send(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return false, 0, newAPIError("/2/files/list_folder/longpoll", resp, body)
+	}
+	var out struct {
+		Changes bool `json:"changes"`
+		Backoff int  `json:"backoff"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, 0, err
+	}
+	return out.Changes, time.Duration(out.Backoff) * time.Second, nil
+}
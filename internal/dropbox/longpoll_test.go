@@ -0,0 +1,61 @@
+package dropbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLongpollIsUnauthenticated(t *testing.T) {
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Host != "notify.dropboxapi.com" {
+					t.Fatalf("expected notify host, got %s", req.URL.Host)
+				}
+				if req.Header.Get("Authorization") != "" {
+					t.Fatal("expected longpoll without Authorization header")
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"changes":true,"backoff":60}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	changed, backoff, err := c.LongpollContext(context.Background(), "cursor", 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || backoff != time.Minute {
+		t.Fatalf("expected changes with 60s backoff, got %v %s", changed, backoff)
+	}
+}
+
+func TestListChangesReportsCursorReset(t *testing.T) {
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusConflict,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"error_summary":"reset/..","error":{".tag":"reset"}}`)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	_, _, err := c.ListChangesContext(context.Background(), "stale")
+	if !errors.Is(err, ErrCursorReset) {
+		t.Fatalf("expected ErrCursorReset, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package dropbox
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,19 +10,20 @@ import (
 	"strings"
 )
 
-func ExchangeAuthCode(appKey, appSecret, code string) (string, error) {
+func ExchangeAuthCode(appKey, appSecret, code string, opts ...Option) (string, error) {
+	c := newClient(appKey, appSecret, "", opts)
 	form := url.Values{}
 	form.Set("grant_type", "authorization_code")
 	form.Set("code", code)
 
-	req, err := http.NewRequest("POST", apiHost+"/oauth2/token", strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(context.Background(), "POST", c.apiURL("/oauth2/token"), strings.NewReader(form.Encode()))
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth(appKey, appSecret)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return "", err
 	}
@@ -44,3 +46,10 @@ func ExchangeAuthCode(appKey, appSecret, code string) (string, error) {
 	}
 	return payload.RefreshToken, nil
 }
+
+// RevokeToken disables the current access token and, with it, the refresh
+// token it was issued from.
+func (c *Client) RevokeToken() error {
+	_, err := c.doAPIRequestNoBody(context.Background(), "/2/auth/token/revoke")
+	return err
+}
@@ -0,0 +1,58 @@
+package dropbox
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRevokeTokenRevokesTheCurrentAccessToken(t *testing.T) {
+	revoked := 0
+	status := http.StatusOK
+	c, err := NewClient("key", "secret", "refresh", WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body := `null`
+			code := http.StatusOK
+			switch req.URL.Path {
+			case "/oauth2/token":
+				body = `{"access_token":"fresh"}`
+			case "/2/auth/token/revoke":
+				if got := req.Header.Get("Authorization"); got != "Bearer fresh" {
+					t.Fatalf("expected the current access token to be revoked, got %q", got)
+				}
+				revoked++
+				code = status
+				if code != http.StatusOK {
+					body = `{"error_summary":"invalid_access_token/..","error":{".tag":"invalid_access_token"}}`
+				}
+			default:
+				t.Fatalf("unexpected endpoint %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: code,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RevokeToken(); err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 {
+		t.Fatalf("expected one revoke call, got %d", revoked)
+	}
+
+	status = http.StatusBadRequest
+	err = c.RevokeToken()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Endpoint != "/2/auth/token/revoke" {
+		t.Fatalf("expected a revoke APIError, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package dropbox
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sends every request, including OAuth token requests and
+// longpolls, to baseURL instead of Dropbox's hosts. It is meant for tests
+// against a fake server such as dropboxtest.Server.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.apiBase = baseURL
+		c.contentBase = baseURL
+		c.notifyBase = baseURL
+	}
+}
+
+// WithHTTPClient replaces the HTTP client used for all requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithTimeouts limits how long a single RPC request may take (api) and how
+// long an upload or download may go without progress (transfer). Zero means
+// no limit.
+func WithTimeouts(api, transfer time.Duration) Option {
+	return func(c *Client) {
+		c.apiTimeout = api
+		c.transferTimeout = transfer
+	}
+}
+
+// WithRateLimits caps the requests per second and the upload and download
+// bandwidth of the client. All requests share the limits, and a 429 from
+// any of them pauses the rest for its Retry-After.
+func WithRateLimits(limits RateLimits) Option {
+	return func(c *Client) {
+		c.limiter = newLimiter(limits)
+	}
+}
+
+func newClient(appKey, appSecret, refreshToken string, opts []Option) *Client {
+	c := &Client{
+		appKey:       appKey,
+		appSecret:    appSecret,
+		refreshToken: refreshToken,
+		client:       &http.Client{},
+		limiter:      newLimiter(RateLimits{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) apiURL(endpoint string) string {
+	return baseOrDefault(c.apiBase, apiHost) + endpoint
+}
+
+func (c *Client) contentURL(endpoint string) string {
+	return baseOrDefault(c.contentBase, contentHost) + endpoint
+}
+
+func (c *Client) notifyURL(endpoint string) string {
+	return baseOrDefault(c.notifyBase, notifyHost) + endpoint
+}
+
+func baseOrDefault(base, fallback string) string {
+	if base == "" {
+		return fallback
+	}
+	return base
+}
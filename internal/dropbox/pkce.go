@@ -0,0 +1,190 @@
+package dropbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	authorizeURL = "https://www.dropbox.com/oauth2/authorize"
+
+	// DefaultRedirectAddr is where the PKCE flow listens for the browser
+	// redirect. http://localhost:53682/callback must be registered as a
+	// redirect URI in the Dropbox app console.
+	DefaultRedirectAddr = "localhost:53682"
+)
+
+// PKCEFlow authorizes rbv with the OAuth code flow plus PKCE, so no app
+// secret is needed. A temporary HTTP server on the loopback interface
+// receives the redirect carrying the authorization code.
+type PKCEFlow struct {
+	AppKey string
+	// RedirectAddr is the host:port to listen on. Defaults to DefaultRedirectAddr.
+	RedirectAddr string
+	// AuthorizeURL and TokenURL default to Dropbox's endpoints.
+	AuthorizeURL string
+	TokenURL     string
+	HTTPClient   *http.Client
+	// OpenURL is handed the authorization URL the user has to visit.
+	OpenURL func(authURL string) error
+}
+
+// Authorize runs the flow and returns the resulting refresh token. It waits
+// for the redirect until ctx is done.
+func (f *PKCEFlow) Authorize(ctx context.Context) (string, error) {
+	verifier, err := randomString(64)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	addr := f.RedirectAddr
+	if addr == "" {
+		addr = DefaultRedirectAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("could not listen for the OAuth redirect on %s: %w", addr, err)
+	}
+	host := addr
+	if _, port, err := net.SplitHostPort(addr); err == nil && port == "0" {
+		host = listener.Addr().String()
+	}
+	redirectURI := "http://" + host + "/callback"
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "State mismatch. Please retry rbv init.", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			http.Error(w, "Authorization was not granted. You can close this window.", http.StatusBadRequest)
+			select {
+			case failures <- fmt.Errorf("dropbox authorization failed: %s: %s", query.Get("error"), query.Get("error_description")):
+			default:
+			}
+			return
+		case query.Get("code") == "":
+			http.Error(w, "Missing authorization code.", http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, "rbv is authorized. You can close this window.\n")
+		select {
+		case codes <- query.Get("code"):
+		default:
+		}
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	params := url.Values{}
+	params.Set("client_id", f.AppKey)
+	params.Set("response_type", "code")
+	params.Set("token_access_type", "offline")
+	params.Set("code_challenge", codeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
+	authURL := f.AuthorizeURL
+	if authURL == "" {
+		authURL = authorizeURL
+	}
+	if f.OpenURL != nil {
+		if err := f.OpenURL(authURL + "?" + params.Encode()); err != nil {
+			return "", err
+		}
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-failures:
+		return "", err
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for dropbox authorization: %w", ctx.Err())
+	}
+	return f.exchange(ctx, code, verifier, redirectURI)
+}
+
+func (f *PKCEFlow) exchange(ctx context.Context, code, verifier, redirectURI string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("client_id", f.AppKey)
+	form.Set("code_verifier", verifier)
+	form.Set("redirect_uri", redirectURI)
+
+	tokenURL := f.TokenURL
+	if tokenURL == "" {
+		tokenURL = apiHost + "/oauth2/token"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := f.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("dropbox auth code exchange failed: %s", strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", err
+	}
+	if payload.RefreshToken == "" {
+		return "", errors.New("dropbox response missing refresh_token")
+	}
+	return payload.RefreshToken, nil
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
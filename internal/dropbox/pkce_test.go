@@ -0,0 +1,100 @@
+package dropbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPKCEFlowExchangesRedirectedCode(t *testing.T) {
+	var challenge string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Error("expected no app secret in the PKCE exchange")
+		}
+		if r.Form.Get("code") != "the-code" || r.Form.Get("client_id") != "key" {
+			t.Errorf("unexpected token form %v", r.Form)
+		}
+		if codeChallenge(r.Form.Get("code_verifier")) != challenge {
+			t.Error("code_verifier does not match code_challenge")
+		}
+		_, _ = io.WriteString(w, `{"access_token":"a","refresh_token":"r"}`)
+	}))
+	defer tokenServer.Close()
+
+	flow := &PKCEFlow{
+		AppKey:       "key",
+		RedirectAddr: "127.0.0.1:0",
+		AuthorizeURL: "https://dropbox.invalid/oauth2/authorize",
+		TokenURL:     tokenServer.URL,
+		OpenURL: func(authURL string) error {
+			parsed, err := url.Parse(authURL)
+			if err != nil {
+				return err
+			}
+			query := parsed.Query()
+			if query.Get("code_challenge_method") != "S256" {
+				t.Errorf("expected S256 challenge, got %q", query.Get("code_challenge_method"))
+			}
+			challenge = query.Get("code_challenge")
+			// Play the browser: follow the redirect back to the loopback server.
+			go func() {
+				redirect := query.Get("redirect_uri") + "?code=the-code&state=" + url.QueryEscape(query.Get("state"))
+				resp, err := http.Get(redirect)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = resp.Body.Close()
+			}()
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	refreshToken, err := flow.Authorize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshToken != "r" {
+		t.Fatalf("expected refresh token r, got %q", refreshToken)
+	}
+}
+
+func TestPKCEFlowReportsDeniedAuthorization(t *testing.T) {
+	flow := &PKCEFlow{
+		AppKey:       "key",
+		RedirectAddr: "127.0.0.1:0",
+		OpenURL: func(authURL string) error {
+			parsed, err := url.Parse(authURL)
+			if err != nil {
+				return err
+			}
+			query := parsed.Query()
+			go func() {
+				redirect := query.Get("redirect_uri") + "?error=access_denied&state=" + url.QueryEscape(query.Get("state"))
+				resp, err := http.Get(redirect)
+				if err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := flow.Authorize(ctx)
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("expected access_denied error, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ProcessedTemplateName names the file_properties template rbv records its
+// processed state with.
+const ProcessedTemplateName = "rbv processed state"
+
+// StatusProcessed marks a source whose output has been published and
+// archived.
+const StatusProcessed = "processed"
+
+// ProcessedState is what rbv records on a source file once it is done.
+type ProcessedState struct {
+	Status      string
+	OutputPath  string
+	ProcessedAt time.Time
+	Version     string
+}
+
+var processedFields = []struct {
+	name        string
+	description string
+}{
+	{"status", "Processing status"},
+	{"output_path", "Path of the archived output"},
+	{"processed_at", "When the source was processed (RFC 3339)"},
+	{"rbv_version", "rbv version that processed the source"},
+}
+
+type propertyField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type propertyGroup struct {
+	TemplateID string          `json:"template_id"`
+	Fields     []propertyField `json:"fields"`
+}
+
+// processedState reads the state from the property groups of a listing
+// entry. Listings only ever ask for rbv's template, so any group with a
+// status is ours.
+func processedState(groups []propertyGroup) *ProcessedState {
+	for _, group := range groups {
+		state := ProcessedState{}
+		for _, field := range group.Fields {
+			switch field.Name {
+			case "status":
+				state.Status = field.Value
+			case "output_path":
+				state.OutputPath = field.Value
+			case "processed_at":
+				state.ProcessedAt, _ = time.Parse(time.RFC3339, field.Value)
+			case "rbv_version":
+				state.Version = field.Value
+			}
+		}
+		if state.Status != "" {
+			return &state
+		}
+	}
+	return nil
+}
+
+// IsProcessed reports whether file carries rbv's processed state.
+func IsProcessed(file FileMetadata) bool {
+	return file.Processed != nil && file.Processed.Status == StatusProcessed
+}
+
+// UseProcessedTemplateContext finds rbv's file_properties template, adding it
+// to the account if it does not exist yet. From then on listings include each
+// file's processed state and MarkProcessedContext can record it. This needs the
+// files.metadata.read and files.metadata.write scopes.
+func (c *Client) UseProcessedTemplateContext(ctx context.Context) (string, error) {
+	id, err := c.findTemplate(ctx, ProcessedTemplateName)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		id, err = c.addProcessedTemplate(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	c.templateID = id
+	return id, nil
+}
+
+// FindProcessedTemplateContext is UseProcessedTemplateContext without adding
+// the template, for callers that must not write to the account. Without the
+// template nothing has been marked yet, so listings simply carry no processed
+// state.
+func (c *Client) FindProcessedTemplateContext(ctx context.Context) (string, error) {
+	id, err := c.findTemplate(ctx, ProcessedTemplateName)
+	if err != nil {
+		return "", err
+	}
+	c.templateID = id
+	return id, nil
+}
+
+func (c *Client) findTemplate(ctx context.Context, name string) (string, error) {
+	resp, err := c.doAPIRequestNoBody(ctx, "/2/file_properties/templates/list_for_user")
+	if err != nil {
+		return "", err
+	}
+	var list struct {
+		TemplateIDs []string `json:"template_ids"`
+	}
+	if err := json.Unmarshal(resp, &list); err != nil {
+		return "", err
+	}
+	for _, id := range list.TemplateIDs {
+		payload, err := json.Marshal(map[string]string{"template_id": id})
+		if err != nil {
+			return "", err
+		}
+		resp, err := c.doAPIRequest(ctx, "/2/file_properties/templates/get_for_user", payload)
+		if err != nil {
+			return "", err
+		}
+		var template struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(resp, &template); err != nil {
+			return "", err
+		}
+		if template.Name == name {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *Client) addProcessedTemplate(ctx context.Context) (string, error) {
+	fields := make([]map[string]any, 0, len(processedFields))
+	for _, field := range processedFields {
+		fields = append(fields, map[string]any{
+			"name":        field.name,
+			"description": field.description,
+			"type":        map[string]string{".tag": "string"},
+		})
+	}
+	payload, err := json.Marshal(map[string]any{
+		"name":        ProcessedTemplateName,
+		"description": "Processing state recorded by rbv on source uploads",
+		"fields":      fields,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/file_properties/templates/add_for_user", payload)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		TemplateID string `json:"template_id"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return "", err
+	}
+	if out.TemplateID == "" {
+		return "", errors.New("dropbox template response missing template_id")
+	}
+	return out.TemplateID, nil
+}
+
+// MarkProcessedContext records state on the file at path, replacing any state
+// recorded before. UseProcessedTemplateContext must have been called.
+func (c *Client) MarkProcessedContext(ctx context.Context, path string, state ProcessedState) error {
+	if c.templateID == "" {
+		return errors.New("dropbox processed-state template is not set up")
+	}
+	processedAt := ""
+	if !state.ProcessedAt.IsZero() {
+		processedAt = state.ProcessedAt.UTC().Format(time.RFC3339)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"path": path,
+		"property_groups": []propertyGroup{{
+			TemplateID: c.templateID,
+			Fields: []propertyField{
+				{Name: "status", Value: state.Status},
+				{Name: "output_path", Value: state.OutputPath},
+				{Name: "processed_at", Value: processedAt},
+				{Name: "rbv_version", Value: state.Version},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.doAPIRequest(ctx, "/2/file_properties/properties/overwrite", payload)
+	return err
+}
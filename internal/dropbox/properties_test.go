@@ -0,0 +1,140 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUseProcessedTemplateAddsMissingTemplate(t *testing.T) {
+	var added map[string]any
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body := ""
+				switch req.URL.Path {
+				case "/2/file_properties/templates/list_for_user":
+					body = `{"template_ids":["ptid:other"]}`
+				case "/2/file_properties/templates/get_for_user":
+					body = `{"name":"Something else","fields":[]}`
+				case "/2/file_properties/templates/add_for_user":
+					if err := json.NewDecoder(req.Body).Decode(&added); err != nil {
+						t.Fatal(err)
+					}
+					body = `{"template_id":"ptid:rbv"}`
+				default:
+					t.Fatalf("unexpected endpoint %s", req.URL.Path)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	id, err := c.UseProcessedTemplateContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "ptid:rbv" || c.templateID != "ptid:rbv" {
+		t.Fatalf("expected the added template to be used, got %q", id)
+	}
+	if added["name"] != ProcessedTemplateName {
+		t.Fatalf("unexpected template %v", added)
+	}
+}
+
+func TestFindProcessedTemplateNeverAddsTheTemplate(t *testing.T) {
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body := ""
+				switch req.URL.Path {
+				case "/2/file_properties/templates/list_for_user":
+					body = `{"template_ids":["ptid:other"]}`
+				case "/2/file_properties/templates/get_for_user":
+					body = `{"name":"Something else","fields":[]}`
+				default:
+					t.Fatalf("unexpected endpoint %s", req.URL.Path)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	id, err := c.FindProcessedTemplateContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "" || c.templateID != "" {
+		t.Fatalf("expected no template without adding one, got %q", id)
+	}
+}
+
+func TestListFilesToProcessSkipsMarkedFiles(t *testing.T) {
+	var listArg map[string]any
+	c := &Client{
+		accessToken: "token",
+		templateID:  "ptid:rbv",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				var arg map[string]any
+				if err := json.NewDecoder(req.Body).Decode(&arg); err != nil {
+					t.Fatal(err)
+				}
+				body := `{"entries":[],"has_more":false}`
+				if arg["path"] == "/live" {
+					listArg = arg
+					// The marked file was renamed in the archive, so only its
+					// property says it is done.
+					body = `{"entries":[
+						{".tag":"file","name":"a.mp3","path_lower":"/live/a.mp3","client_modified":"2024-03-09T20:00:00Z",
+						 "property_groups":[{"template_id":"ptid:rbv","fields":[{"name":"status","value":"processed"},{"name":"output_path","value":"/archive/renamed.mp3"},{"name":"processed_at","value":"2024-03-10T08:00:00Z"}]}]},
+						{".tag":"file","name":"b.mp3","path_lower":"/live/b.mp3","client_modified":"2024-03-09T20:00:00Z"}
+					],"has_more":false}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	files, err := c.ListFilesToProcessContext(context.Background(), []string{"/live"}, "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "b.mp3" {
+		t.Fatalf("expected only b.mp3 to be pending, got %+v", files)
+	}
+	groups, _ := listArg["include_property_groups"].(map[string]any)
+	if groups[".tag"] != "filter_some" {
+		t.Fatalf("expected the listing to ask for rbv's properties, got %v", listArg)
+	}
+
+	all, err := c.ListFilesContext(context.Background(), "/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := all[0].Processed
+	if state == nil || state.OutputPath != "/archive/renamed.mp3" || !state.ProcessedAt.Equal(time.Date(2024, 3, 10, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected processed state %+v", state)
+	}
+}
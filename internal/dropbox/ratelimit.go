@@ -0,0 +1,191 @@
+package dropbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimits caps the traffic of a Client. Zero fields leave that part
+// unlimited.
+type RateLimits struct {
+	RequestsPerSecond      float64
+	UploadBytesPerSecond   int64
+	DownloadBytesPerSecond int64
+}
+
+// throttleChunk bounds how much a throttled body reads at a time, so
+// transfers are paced smoothly rather than in 4 MiB bursts.
+const throttleChunk = 32 * 1024
+
+// limiter is the token-bucket scheduler every request of a Client goes
+// through. It also holds the pause a 429 imposes on all requests. A nil
+// limiter lets everything through.
+type limiter struct {
+	mu          sync.Mutex
+	requests    *bucket
+	upload      *bucket
+	download    *bucket
+	pausedUntil time.Time
+}
+
+// traffic selects the bucket a wait draws from.
+type traffic int
+
+const (
+	requestTraffic traffic = iota
+	uploadTraffic
+	downloadTraffic
+)
+
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	burst := limits.RequestsPerSecond
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		requests: newBucket(limits.RequestsPerSecond, burst),
+		upload:   newBucket(float64(limits.UploadBytesPerSecond), float64(limits.UploadBytesPerSecond)),
+		download: newBucket(float64(limits.DownloadBytesPerSecond), float64(limits.DownloadBytesPerSecond)),
+	}
+}
+
+// newBucket returns a full bucket of burst tokens refilled at rate per
+// second. A non-positive rate means no limit.
+func newBucket(rate, burst float64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n tokens, going into debt if there are not enough, and
+// returns how long the caller has to wait until they are paid back.
+func (b *bucket) reserve(now time.Time, n float64) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (l *limiter) bucket(kind traffic) *bucket {
+	switch kind {
+	case uploadTraffic:
+		return l.upload
+	case downloadTraffic:
+		return l.download
+	default:
+		return l.requests
+	}
+}
+
+// wait blocks until any pause is over and n tokens of kind are available.
+func (l *limiter) wait(ctx context.Context, kind traffic, n float64) error {
+	if l == nil {
+		return nil
+	}
+	b := l.bucket(kind)
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if paused := l.pausedUntil.Sub(now); paused > 0 {
+			l.mu.Unlock()
+			if err := sleep(ctx, paused); err != nil {
+				return err
+			}
+			continue
+		}
+		var delay time.Duration
+		if b != nil {
+			delay = b.reserve(now, n)
+		}
+		l.mu.Unlock()
+		return sleep(ctx, delay)
+	}
+}
+
+// pause holds back every request for d.
+func (l *limiter) pause(d time.Duration) {
+	if l == nil || d <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// reader paces reads from body against the kind's bandwidth cap.
+func (l *limiter) reader(ctx context.Context, body io.ReadCloser, kind traffic) io.ReadCloser {
+	if l == nil || l.bucket(kind) == nil {
+		return body
+	}
+	return &throttledReader{ReadCloser: body, ctx: ctx, limiter: l, kind: kind}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *limiter
+	kind    traffic
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, r.kind, float64(n)); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// send is the single place requests leave the Client. It waits for a
+// request token, and a 429 with Retry-After pauses every request, not just
+// the one that was throttled.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if err := c.limiter.wait(req.Context(), requestTraffic, 1); err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		c.limiter.pause(parseRetryAfter(resp.Header.Get("Retry-After")))
+	}
+	return resp, nil
+}
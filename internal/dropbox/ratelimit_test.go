@@ -0,0 +1,60 @@
+package dropbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedResponsePausesAllRequests(t *testing.T) {
+	var calls atomic.Int32
+	c := newClient("key", "secret", "refresh", []Option{WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			status, header := http.StatusOK, http.Header{}
+			if calls.Add(1) == 1 {
+				status = http.StatusTooManyRequests
+				header.Set("Retry-After", "1")
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     header,
+				Body:       io.NopCloser(strings.NewReader(`{".tag":"file","name":"a.mp3"}`)),
+				Request:    req,
+			}, nil
+		}),
+	})})
+	c.accessToken = "token"
+
+	if _, err := c.GetMetadataContext(context.Background(), "/a.mp3"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	start := time.Now()
+	if _, err := c.GetMetadataContext(context.Background(), "/b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Fatalf("expected the next request to wait out Retry-After, waited %s", waited)
+	}
+}
+
+func TestThrottledReaderCapsBandwidth(t *testing.T) {
+	l := newLimiter(RateLimits{DownloadBytesPerSecond: 1 << 20})
+	body := l.reader(context.Background(), io.NopCloser(bytes.NewReader(make([]byte, 3<<19))), downloadTraffic)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first second's worth is the burst; the remaining half MiB takes
+	// about half a second.
+	if elapsed := time.Since(start); n != 3<<19 || elapsed < 400*time.Millisecond {
+		t.Fatalf("expected 1.5 MiB at 1 MiB/s to take about 0.5s, read %d bytes in %s", n, elapsed)
+	}
+}
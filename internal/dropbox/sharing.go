@@ -0,0 +1,102 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SharedLinkSettings restrict a new shared link. Zero values leave the
+// link without an expiry or password.
+type SharedLinkSettings struct {
+	Expires  time.Time
+	Password string
+}
+
+// CreateSharedLinkContext returns a shared link for path. If the file already
+// has one, that link is reused as is, even when its settings differ.
+func (c *Client) CreateSharedLinkContext(ctx context.Context, path string, settings SharedLinkSettings) (string, error) {
+	linkSettings := map[string]any{}
+	if !settings.Expires.IsZero() {
+		linkSettings["expires"] = settings.Expires.UTC().Format(time.RFC3339)
+	}
+	if settings.Password != "" {
+		linkSettings["require_password"] = true
+		linkSettings["link_password"] = settings.Password
+	}
+	arg := map[string]any{"path": path}
+	if len(linkSettings) > 0 {
+		arg["settings"] = linkSettings
+	}
+	payload, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/sharing/create_shared_link_with_settings", payload)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.HasTag("shared_link_already_exists") {
+		if url := existingLinkURL(apiErr.Body); url != "" {
+			return url, nil
+		}
+		return c.existingSharedLink(ctx, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	var link struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(resp, &link); err != nil {
+		return "", err
+	}
+	if link.URL == "" {
+		return "", errors.New("dropbox shared link response missing url")
+	}
+	return link.URL, nil
+}
+
+// existingLinkURL reads the link Dropbox includes in a
+// shared_link_already_exists error, when it does.
+func existingLinkURL(body string) string {
+	var out struct {
+		Error struct {
+			Exists struct {
+				Metadata struct {
+					URL string `json:"url"`
+				} `json:"metadata"`
+			} `json:"shared_link_already_exists"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		return ""
+	}
+	return out.Error.Exists.Metadata.URL
+}
+
+func (c *Client) existingSharedLink(ctx context.Context, path string) (string, error) {
+	payload, err := json.Marshal(map[string]any{
+		"path":        path,
+		"direct_only": true,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doAPIRequest(ctx, "/2/sharing/list_shared_links", payload)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Links []struct {
+			URL string `json:"url"`
+		} `json:"links"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return "", err
+	}
+	if len(out.Links) == 0 {
+		return "", fmt.Errorf("dropbox reported a shared link for %s but listed none", path)
+	}
+	return out.Links[0].URL, nil
+}
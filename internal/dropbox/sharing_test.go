@@ -0,0 +1,57 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateSharedLinkSendsSettingsAndReusesExisting(t *testing.T) {
+	var settings map[string]any
+	c := &Client{
+		accessToken: "token",
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				status, body := http.StatusOK, ""
+				switch req.URL.Path {
+				case "/2/sharing/create_shared_link_with_settings":
+					var arg struct {
+						Settings map[string]any `json:"settings"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&arg); err != nil {
+						t.Fatal(err)
+					}
+					settings = arg.Settings
+					status = http.StatusConflict
+					body = `{"error_summary":"shared_link_already_exists/..","error":{".tag":"shared_link_already_exists"}}`
+				case "/2/sharing/list_shared_links":
+					body = `{"links":[{"url":"https://www.dropbox.com/s/abc/show.mp3?dl=0"}]}`
+				default:
+					t.Fatalf("unexpected endpoint %s", req.URL.Path)
+				}
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	expires := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	link, err := c.CreateSharedLinkContext(context.Background(), "/post/show.mp3", SharedLinkSettings{Expires: expires, Password: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "https://www.dropbox.com/s/abc/show.mp3?dl=0" {
+		t.Fatalf("expected the existing link, got %q", link)
+	}
+	if settings["expires"] != "2024-04-01T12:00:00Z" || settings["link_password"] != "hunter2" || settings["require_password"] != true {
+		t.Fatalf("unexpected link settings %v", settings)
+	}
+}
@@ -0,0 +1,122 @@
+package dropbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ResolveSources prepares the listing of the preprocess folders for
+// processing. A file listed twice, because two preprocess paths name the
+// same folder, is kept once. Files with the same content_hash are one
+// recording uploaded more than once, so only the earliest upload is kept.
+// Different files that would be published under the same name are taken in upload order: the
+// first keeps the name and later ones get a " (2)", " (3)", ... suffix,
+// which stays the same from run to run as long as the folders do. The
+// result keeps the order of files.
+func ResolveSources(files []FileMetadata) []FileMetadata {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return uploadedBefore(files[order[a]], files[order[b]])
+	})
+
+	resolved := make([]*FileMetadata, len(files))
+	listed := make(map[string]struct{}, len(files))
+	hashes := make(map[string]struct{}, len(files))
+	names := make(map[string]int, len(files))
+	for _, i := range order {
+		file := files[i]
+		key := sourceKey(file)
+		if _, seen := listed[key]; seen {
+			continue
+		}
+		listed[key] = struct{}{}
+		if file.ContentHash != "" {
+			if _, seen := hashes[file.ContentHash]; seen {
+				continue
+			}
+			hashes[file.ContentHash] = struct{}{}
+		}
+		name := strings.ToLower(baseName(file))
+		names[name]++
+		file.PublishedName = ""
+		if n := names[name]; n > 1 {
+			file.PublishedName = suffixed(baseName(file), n)
+		}
+		resolved[i] = &file
+	}
+
+	result := make([]FileMetadata, 0, len(files))
+	for _, file := range resolved {
+		if file != nil {
+			result = append(result, *file)
+		}
+	}
+	return result
+}
+
+// uploadedBefore orders files by when Dropbox received them, falling back to
+// the id and path so the order never depends on the listing.
+func uploadedBefore(a, b FileMetadata) bool {
+	if !a.ServerModified.Equal(b.ServerModified) {
+		return a.ServerModified.Before(b.ServerModified)
+	}
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.PathLower < b.PathLower
+}
+
+func sourceKey(file FileMetadata) string {
+	if file.ID != "" {
+		return file.ID
+	}
+	return file.PathLower
+}
+
+func suffixed(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// ResolveArchiveNames moves sources off published names that an earlier
+// recording already holds in the archive. ResolveSources only sees the
+// current listing, so once a source is moved out of the preprocess folder
+// a later file with the same name and day would otherwise map onto its
+// archive copy and be taken as done. An archive file older than the source
+// itself cannot be that source's output, so the source gets the next
+// suffix no other listed file or earlier archive file uses. lookup returns
+// the archive file published under name, if there is one.
+func ResolveArchiveNames(files []FileMetadata, lookup func(name string) (FileMetadata, bool, error)) ([]FileMetadata, error) {
+	taken := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		taken[strings.ToLower(RenameFile(file))] = struct{}{}
+	}
+	resolved := make([]FileMetadata, len(files))
+	copy(resolved, files)
+	for i := range resolved {
+		file := &resolved[i]
+		for {
+			archived, ok, err := lookup(RenameFile(*file))
+			if err != nil {
+				return nil, err
+			}
+			if !ok || !archived.ServerModified.Before(file.ServerModified) {
+				break
+			}
+			for n := 2; ; n++ {
+				name := suffixed(baseName(*file), n)
+				if _, used := taken[strings.ToLower(name)]; !used {
+					file.PublishedName = name
+					taken[strings.ToLower(name)] = struct{}{}
+					break
+				}
+			}
+		}
+	}
+	return resolved, nil
+}
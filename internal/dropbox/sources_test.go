@@ -0,0 +1,85 @@
+package dropbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveSourcesDropsReuploadsAndSuffixesCollisions(t *testing.T) {
+	day := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	files := []FileMetadata{
+		{ID: "id:3", Name: "mix.mp3", PathLower: "/prerecord/mix.mp3", ClientModified: day.Add(20 * time.Hour), ServerModified: day.Add(21 * time.Hour), ContentHash: "bbb"},
+		{ID: "id:1", Name: "mix.mp3", PathLower: "/live/mix.mp3", ClientModified: day.Add(9 * time.Hour), ServerModified: day.Add(10 * time.Hour), ContentHash: "aaa"},
+		{ID: "id:2", Name: "mix again.mp3", PathLower: "/live/mix again.mp3", ClientModified: day.Add(11 * time.Hour), ServerModified: day.Add(12 * time.Hour), ContentHash: "aaa"},
+	}
+
+	for _, input := range [][]FileMetadata{files, {files[2], files[1], files[0]}} {
+		resolved := ResolveSources(input)
+		if len(resolved) != 2 {
+			t.Fatalf("expected the re-upload to be dropped, got %+v", resolved)
+		}
+		names := map[string]string{}
+		for _, file := range resolved {
+			names[file.ID] = RenameFile(file)
+		}
+		if names["id:1"] != "mix - Radio Buena Vida 09.03.24.mp3" {
+			t.Fatalf("expected the earliest upload to keep its name, got %q", names["id:1"])
+		}
+		if names["id:3"] != "mix - Radio Buena Vida 09.03.24 (2).mp3" {
+			t.Fatalf("expected the later upload to get a suffix, got %q", names["id:3"])
+		}
+	}
+}
+
+func TestResolveSourcesKeepsFilesListedTwiceOnce(t *testing.T) {
+	day := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	file := FileMetadata{ID: "id:1", Name: "mix.mp3", PathLower: "/preprocessed/mix.mp3", ClientModified: day, ServerModified: day}
+	local := FileMetadata{Name: "show.mp3", PathLower: "/preprocessed/show.mp3", ClientModified: day, ServerModified: day}
+
+	resolved := ResolveSources([]FileMetadata{file, local, file, local})
+	if len(resolved) != 2 {
+		t.Fatalf("expected each file once, got %+v", resolved)
+	}
+	for _, file := range resolved {
+		if file.PublishedName != "" {
+			t.Fatalf("expected no suffix for a file listed twice, got %q", file.PublishedName)
+		}
+	}
+}
+
+func TestResolveArchiveNamesSkipsNamesOfEarlierRecordings(t *testing.T) {
+	day := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	earlier := FileMetadata{Name: "mix.mp3", ClientModified: day.Add(9 * time.Hour)}
+	archive := map[string]FileMetadata{
+		RenameFile(earlier):                       {Name: RenameFile(earlier), ServerModified: day.Add(11 * time.Hour)},
+		"mix - Radio Buena Vida 09.03.24 (3).mp3": {ServerModified: day.Add(12 * time.Hour)},
+	}
+	lookup := func(name string) (FileMetadata, bool, error) {
+		file, ok := archive[name]
+		return file, ok, nil
+	}
+	files := ResolveSources([]FileMetadata{
+		{ID: "id:2", Name: "mix.mp3", PathLower: "/live/mix.mp3", ClientModified: day.Add(20 * time.Hour), ServerModified: day.Add(20 * time.Hour)},
+		{ID: "id:3", Name: "mix.mp3", PathLower: "/prerecord/mix.mp3", ClientModified: day.Add(21 * time.Hour), ServerModified: day.Add(21 * time.Hour)},
+	})
+
+	resolved, err := ResolveArchiveNames(files, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := RenameFile(resolved[0]); got != "mix - Radio Buena Vida 09.03.24 (4).mp3" {
+		t.Fatalf("expected the later upload to skip the taken and archived names, got %q", got)
+	}
+	if got := RenameFile(resolved[1]); got != "mix - Radio Buena Vida 09.03.24 (2).mp3" {
+		t.Fatalf("expected the second upload to keep its suffix, got %q", got)
+	}
+
+	own := []FileMetadata{{Name: "mix.mp3", ClientModified: day.Add(9 * time.Hour), ServerModified: day.Add(10 * time.Hour)}}
+	resolved, err = ResolveArchiveNames(own, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved[0].PublishedName != "" {
+		t.Fatalf("expected a source to keep the name of its own archive copy, got %q", resolved[0].PublishedName)
+	}
+}
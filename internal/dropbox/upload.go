@@ -0,0 +1,243 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const sessionSuffix = ".upload"
+
+// maxOffsetResyncs bounds how often one call follows incorrect_offset before
+// giving up, so a confused server cannot keep us looping.
+const maxOffsetResyncs = 3
+
+// uploadState is persisted next to the local file after every chunk so a
+// restarted rbv can finish the session instead of re-sending the file.
+type uploadState struct {
+	SessionID  string    `json:"session_id"`
+	Offset     int64     `json:"offset"`
+	RemotePath string    `json:"remote_path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+}
+
+func (c *Client) uploadSession(ctx context.Context, file *os.File, localPath string, commit writeCommit, info os.FileInfo) (fileEntry, error) {
+	statePath := localPath + sessionSuffix
+	state, ok := loadUploadState(statePath)
+	if !ok || state.RemotePath != commit.Path || state.Size != info.Size() || !state.ModTime.Equal(info.ModTime()) {
+		state = uploadState{
+			RemotePath: commit.Path,
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+		}
+	}
+
+	restarted := false
+	resyncs := 0
+	for {
+		committed, err := c.uploadChunks(ctx, file, &state, statePath, commit)
+		if err == nil {
+			_ = os.Remove(statePath)
+			return committed, nil
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			return fileEntry{}, err
+		}
+		if offset, ok := incorrectOffset(apiErr); ok && resyncs < maxOffsetResyncs {
+			resyncs++
+			state.Offset = offset
+			if err := saveUploadState(statePath, state); err != nil {
+				return fileEntry{}, err
+			}
+			continue
+		}
+		if sessionGone(apiErr) && !restarted && state.SessionID != "" {
+			restarted = true
+			state.SessionID = ""
+			state.Offset = 0
+			_ = os.Remove(statePath)
+			continue
+		}
+		return fileEntry{}, err
+	}
+}
+
+func (c *Client) uploadChunks(ctx context.Context, file *os.File, state *uploadState, statePath string, commit writeCommit) (fileEntry, error) {
+	fileSize := state.Size
+	if state.SessionID == "" {
+		chunk, err := readChunk(file, 0, fileSize)
+		if err != nil {
+			return fileEntry{}, err
+		}
+		sessionID, err := c.startUploadSession(ctx, chunk)
+		if err != nil {
+			return fileEntry{}, err
+		}
+		state.SessionID = sessionID
+		state.Offset = int64(len(chunk))
+		if err := saveUploadState(statePath, *state); err != nil {
+			return fileEntry{}, err
+		}
+	}
+
+	for {
+		chunk, err := readChunk(file, state.Offset, fileSize)
+		if err != nil {
+			return fileEntry{}, err
+		}
+		cursor := map[string]any{
+			"session_id": state.SessionID,
+			"offset":     state.Offset,
+		}
+		if state.Offset+int64(len(chunk)) >= fileSize {
+			finishArg, err := json.Marshal(map[string]any{
+				"cursor": cursor,
+				"commit": commit,
+			})
+			if err != nil {
+				return fileEntry{}, err
+			}
+			var committed fileEntry
+			err = c.doContentRequestDecode(ctx, "/2/files/upload_session/finish", finishArg, chunk, &committed)
+			return committed, err
+		}
+
+		appendArg, err := json.Marshal(map[string]any{
+			"cursor": cursor,
+			"close":  false,
+		})
+		if err != nil {
+			return fileEntry{}, err
+		}
+		if err := c.doContentRequestDecode(ctx, "/2/files/upload_session/append_v2", appendArg, chunk, nil); err != nil {
+			return fileEntry{}, err
+		}
+		state.Offset += int64(len(chunk))
+		if err := saveUploadState(statePath, *state); err != nil {
+			return fileEntry{}, err
+		}
+	}
+}
+
+func (c *Client) startUploadSession(ctx context.Context, chunk []byte) (string, error) {
+	startArg, err := json.Marshal(map[string]bool{"close": false})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doContentRequest(ctx, "/2/files/upload_session/start", startArg, chunk)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", newAPIError("/2/files/upload_session/start", resp, body)
+	}
+	var startResp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&startResp); err != nil {
+		return "", err
+	}
+	if startResp.SessionID == "" {
+		return "", errors.New("dropbox upload session start missing session_id")
+	}
+	return startResp.SessionID, nil
+}
+
+// doContentRequestDecode sends a content request and decodes the JSON result
+// into out, or discards it when out is nil.
+func (c *Client) doContentRequestDecode(ctx context.Context, endpoint string, arg, body []byte, out any) error {
+	resp, err := c.doContentRequest(ctx, endpoint, arg, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return newAPIError(endpoint, resp, respBody)
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func readChunk(file *os.File, offset, fileSize int64) ([]byte, error) {
+	if offset > fileSize {
+		return nil, fmt.Errorf("upload offset %d beyond file size %d", offset, fileSize)
+	}
+	chunk := make([]byte, int(minInt64(fileSize-offset, int64(chunkSize))))
+	n, err := file.ReadAt(chunk, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return chunk[:n], nil
+}
+
+// incorrectOffset extracts correct_offset from an upload session error. It is
+// nested under lookup_failed for finish calls and at the top for append_v2.
+func incorrectOffset(apiErr *APIError) (int64, bool) {
+	var payload struct {
+		Error struct {
+			Tag           string `json:".tag"`
+			CorrectOffset *int64 `json:"correct_offset"`
+			LookupFailed  *struct {
+				Tag           string `json:".tag"`
+				CorrectOffset *int64 `json:"correct_offset"`
+			} `json:"lookup_failed"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(apiErr.Body), &payload); err != nil {
+		return 0, false
+	}
+	if payload.Error.Tag == "incorrect_offset" && payload.Error.CorrectOffset != nil {
+		return *payload.Error.CorrectOffset, true
+	}
+	if lookup := payload.Error.LookupFailed; lookup != nil && lookup.Tag == "incorrect_offset" && lookup.CorrectOffset != nil {
+		return *lookup.CorrectOffset, true
+	}
+	return 0, false
+}
+
+// sessionGone reports whether the server no longer knows the session, for
+// example because it expired while rbv was not running.
+func sessionGone(apiErr *APIError) bool {
+	return errors.Is(apiErr, ErrNotFound) || apiErr.HasTag("closed")
+}
+
+func loadUploadState(path string) (uploadState, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return uploadState{}, false
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil || state.SessionID == "" {
+		return uploadState{}, false
+	}
+	return state, true
+}
+
+func saveUploadState(path string, state uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
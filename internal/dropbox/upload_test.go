@@ -0,0 +1,201 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type uploadCall struct {
+	endpoint string
+	offset   int64
+	size     int
+}
+
+func fakeUploadClient(t *testing.T, handle func(call uploadCall) (int, string)) (*Client, *[]uploadCall) {
+	t.Helper()
+	var calls []uploadCall
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body, err := io.ReadAll(req.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var arg struct {
+					Cursor struct {
+						Offset int64 `json:"offset"`
+					} `json:"cursor"`
+				}
+				_ = json.Unmarshal([]byte(req.Header.Get("Dropbox-API-Arg")), &arg)
+				call := uploadCall{endpoint: req.URL.Path, offset: arg.Cursor.Offset, size: len(body)}
+				calls = append(calls, call)
+				status, respBody := handle(call)
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(respBody)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+	return c, &calls
+}
+
+func writeUploadFixture(t *testing.T, size int) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ex-show.mp3")
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := FileContentHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, `{"rev":"r1","content_hash":"` + hash + `"}`
+}
+
+func TestUploadFileResyncsOnIncorrectOffset(t *testing.T) {
+	localPath, committed := writeUploadFixture(t, 2*chunkSize+1024)
+	resynced := false
+	c, calls := fakeUploadClient(t, func(call uploadCall) (int, string) {
+		switch call.endpoint {
+		case "/2/files/upload_session/start":
+			return http.StatusOK, `{"session_id":"s1"}`
+		case "/2/files/upload_session/append_v2":
+			if !resynced {
+				resynced = true
+				return http.StatusConflict, `{"error_summary":"incorrect_offset/..","error":{".tag":"incorrect_offset","correct_offset":8388608}}`
+			}
+			t.Fatalf("unexpected append after resync at offset %d", call.offset)
+		case "/2/files/upload_session/finish":
+			return http.StatusOK, committed
+		}
+		t.Fatalf("unexpected endpoint %s", call.endpoint)
+		return 0, ""
+	})
+
+	if _, err := c.UploadFileContext(context.Background(), localPath, "/post/show.mp3", PolicyOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	last := (*calls)[len(*calls)-1]
+	if last.endpoint != "/2/files/upload_session/finish" || last.offset != 2*chunkSize || last.size != 1024 {
+		t.Fatalf("expected finish at server offset with final 1024 bytes, got %+v", last)
+	}
+	if _, err := os.Stat(localPath + sessionSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected session state to be removed, got %v", err)
+	}
+}
+
+func TestUploadFileResumesPersistedSession(t *testing.T) {
+	localPath, committed := writeUploadFixture(t, 2*chunkSize+1024)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveUploadState(localPath+sessionSuffix, uploadState{
+		SessionID:  "s1",
+		Offset:     chunkSize,
+		RemotePath: "/post/show.mp3",
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, calls := fakeUploadClient(t, func(call uploadCall) (int, string) {
+		if call.endpoint == "/2/files/upload_session/start" {
+			t.Fatal("expected persisted session to be reused")
+		}
+		if call.endpoint == "/2/files/upload_session/finish" {
+			return http.StatusOK, committed
+		}
+		return http.StatusOK, `{}`
+	})
+
+	if _, err := c.UploadFileContext(context.Background(), localPath, "/post/show.mp3", PolicyOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) != 2 || (*calls)[0].offset != chunkSize {
+		t.Fatalf("expected append from offset %d then finish, got %+v", chunkSize, *calls)
+	}
+}
+
+func TestUploadFileDeletesCommitWithMismatchedHash(t *testing.T) {
+	localPath, _ := writeUploadFixture(t, 1024)
+	deleted := false
+	c, _ := fakeUploadClient(t, func(call uploadCall) (int, string) {
+		switch call.endpoint {
+		case "/2/files/upload":
+			return http.StatusOK, `{"rev":"r1","content_hash":"deadbeef"}`
+		case "/2/files/delete_v2":
+			deleted = true
+			return http.StatusOK, `{}`
+		}
+		t.Fatalf("unexpected endpoint %s", call.endpoint)
+		return 0, ""
+	})
+
+	_, err := c.UploadFileContext(context.Background(), localPath, "/post/show.mp3", PolicyOverwrite)
+	if !errors.Is(err, ErrContentHashMismatch) {
+		t.Fatalf("expected ErrContentHashMismatch, got %v", err)
+	}
+	if !deleted {
+		t.Fatal("expected mismatched upload to be deleted")
+	}
+}
+
+func TestUploadFileSkipsIdenticalExistingFile(t *testing.T) {
+	localPath, existing := writeUploadFixture(t, 1024)
+	c, calls := fakeUploadClient(t, func(call uploadCall) (int, string) {
+		if call.endpoint != "/2/files/get_metadata" {
+			t.Fatalf("expected no upload for an identical file, got %s", call.endpoint)
+		}
+		return http.StatusOK, existing
+	})
+
+	got, err := c.UploadFileContext(context.Background(), localPath, "/post/show.mp3", PolicySkipIfIdentical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rev != "r1" || len(*calls) != 1 {
+		t.Fatalf("expected the existing file back after one lookup, got %+v after %d calls", got, len(*calls))
+	}
+}
+
+func TestUploadFileUpdatesByRev(t *testing.T) {
+	localPath, committed := writeUploadFixture(t, 1024)
+	var mode string
+	c := &Client{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body := `{"rev":"old","content_hash":"other"}`
+				if req.URL.Path == "/2/files/upload" {
+					mode = req.Header.Get("Dropbox-API-Arg")
+					body = committed
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	if _, err := c.UploadFileContext(context.Background(), localPath, "/post/show.mp3", PolicyUpdateByRev); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mode, `"mode":{".tag":"update","update":"old"}`) {
+		t.Fatalf("expected update mode with the existing rev, got %s", mode)
+	}
+}
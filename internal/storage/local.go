@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"radiobuenavia/internal/dropbox"
+)
+
+// Local is a Backend over a directory tree, such as a mounted NAS share.
+// Remote paths like "/automation/archive" resolve beneath root.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("could not find storage root: %s", root)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("storage root is not a directory: %s", root)
+	}
+	return &Local{root: root}, nil
+}
+
+func (l *Local) ListFilesContext(ctx context.Context, path string) ([]dropbox.FileMetadata, error) {
+	entries, err := os.ReadDir(l.localPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", dropbox.ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []dropbox.FileMetadata{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dropbox.FileMetadata{
+			Name:           entry.Name(),
+			PathLower:      remotePath(path, entry.Name()),
+			ClientModified: info.ModTime().UTC(),
+			ServerModified: info.ModTime().UTC(),
+			Size:           info.Size(),
+		})
+	}
+	return files, nil
+}
+
+func (l *Local) GetMetadataContext(ctx context.Context, path string) (dropbox.FileMetadata, error) {
+	file, err := l.metadata(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dropbox.FileMetadata{}, fmt.Errorf("%w: %w", dropbox.ErrNotFound, err)
+	}
+	return file, err
+}
+
+func (l *Local) ListFilesToProcessContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]dropbox.FileMetadata, error) {
+	preproc, err := l.listSources(ctx, preprocessPaths)
+	if err != nil {
+		return nil, err
+	}
+	// A missing archive just means nothing has been processed yet.
+	archive, err := l.ListFilesContext(ctx, archivePath)
+	if err != nil && !errors.Is(err, dropbox.ErrNotFound) {
+		return nil, fmt.Errorf("list %s: %w", archivePath, err)
+	}
+	archiveNames := make(map[string]dropbox.FileMetadata, len(archive))
+	for _, file := range archive {
+		archiveNames[file.Name] = file
+	}
+	preproc, err = dropbox.ResolveArchiveNames(preproc, func(name string) (dropbox.FileMetadata, bool, error) {
+		file, ok := archiveNames[name]
+		return file, ok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dropbox.FileMetadata, 0, len(preproc))
+	for _, file := range preproc {
+		if _, exists := archiveNames[dropbox.RenameFile(file)]; !exists {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+func (l *Local) ListPendingFilesContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]dropbox.FileMetadata, error) {
+	preproc, err := l.listSources(ctx, preprocessPaths)
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(name string) (dropbox.FileMetadata, bool, error) {
+		file, err := l.metadata(remotePath(archivePath, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return dropbox.FileMetadata{}, false, nil
+		}
+		return file, err == nil, err
+	}
+	preproc, err = dropbox.ResolveArchiveNames(preproc, lookup)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dropbox.FileMetadata, 0, len(preproc))
+	for _, file := range preproc {
+		_, exists, err := lookup(dropbox.RenameFile(file))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// listSources lists the preprocess folders together. Local listings carry
+// no content hash, so only name collisions are resolved.
+func (l *Local) listSources(ctx context.Context, preprocessPaths []string) ([]dropbox.FileMetadata, error) {
+	var files []dropbox.FileMetadata
+	for _, path := range preprocessPaths {
+		listed, err := l.ListFilesContext(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", path, err)
+		}
+		files = append(files, listed...)
+	}
+	return dropbox.ResolveSources(files), nil
+}
+
+func (l *Local) MoveToProcessedContext(ctx context.Context, file dropbox.FileMetadata, processedPath string) (dropbox.FileMetadata, error) {
+	if err := os.MkdirAll(l.localPath(processedPath), 0o755); err != nil {
+		return dropbox.FileMetadata{}, err
+	}
+	dst := l.freePath(remotePath(processedPath, file.Name))
+	if err := os.Rename(l.localPath(file.PathLower), l.localPath(dst)); err != nil {
+		return dropbox.FileMetadata{}, err
+	}
+	return l.metadata(dst)
+}
+
+func (l *Local) DownloadFileContext(ctx context.Context, localPath string, file dropbox.FileMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := copyFile(l.localPath(file.PathLower), localPath); err != nil {
+		return err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if file.Size > 0 && info.Size() != file.Size {
+		return fmt.Errorf("download %s incomplete: got %d of %d bytes: %w", file.PathLower, info.Size(), file.Size, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (l *Local) UploadFileSoundcloudContext(ctx context.Context, localPath, name, soundcloudPath string, policy dropbox.WritePolicy) (dropbox.FileMetadata, error) {
+	return l.write(ctx, localPath, remotePath(soundcloudPath, name), policy)
+}
+
+func (l *Local) CopyToArchiveContext(ctx context.Context, name, soundcloudPath, archivePath string, policy dropbox.WritePolicy) (dropbox.FileMetadata, error) {
+	fromPath := l.localPath(remotePath(soundcloudPath, name))
+	return l.write(ctx, fromPath, remotePath(archivePath, name), policy)
+}
+
+// CopyBatchToArchiveContext copies the files one at a time; there is no job to
+// batch them into locally.
+func (l *Local) CopyBatchToArchiveContext(ctx context.Context, names []string, soundcloudPath, archivePath string, policy dropbox.WritePolicy) ([]dropbox.CopyResult, error) {
+	results := make([]dropbox.CopyResult, 0, len(names))
+	for _, name := range names {
+		copied, err := l.CopyToArchiveContext(ctx, name, soundcloudPath, archivePath, policy)
+		results = append(results, dropbox.CopyResult{Name: name, Metadata: copied, Err: err})
+	}
+	return results, nil
+}
+
+// write copies src to the remote path dst, resolving an existing file with
+// the same rules as the Dropbox backend. There are no revisions locally, so
+// update-by-rev behaves like overwrite.
+func (l *Local) write(ctx context.Context, src, dst string, policy dropbox.WritePolicy) (dropbox.FileMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return dropbox.FileMetadata{}, err
+	}
+	target := l.localPath(dst)
+	_, err := os.Stat(target)
+	exists := err == nil
+	switch policy {
+	case dropbox.PolicyOverwrite, dropbox.PolicyUpdateByRev:
+	case dropbox.PolicyAutorename:
+		dst = l.freePath(dst)
+		target = l.localPath(dst)
+	case dropbox.PolicySkipIfIdentical, "":
+		if exists {
+			identical, err := sameContent(src, target)
+			if err != nil {
+				return dropbox.FileMetadata{}, err
+			}
+			if !identical {
+				return dropbox.FileMetadata{}, fmt.Errorf("%w: %w", dropbox.ErrConflict, &os.PathError{Op: "copy", Path: target, Err: os.ErrExist})
+			}
+			return l.metadata(dst)
+		}
+	default:
+		return dropbox.FileMetadata{}, fmt.Errorf("unknown write policy %q", policy)
+	}
+	if err := copyFile(src, target); err != nil {
+		return dropbox.FileMetadata{}, err
+	}
+	return l.metadata(dst)
+}
+
+// freePath returns remote, or the first "name (n).ext" variant of it that
+// does not exist yet, the way Dropbox autorenames.
+func (l *Local) freePath(remote string) string {
+	ext := path.Ext(remote)
+	candidate := remote
+	for i := 1; ; i++ {
+		if _, err := os.Stat(l.localPath(candidate)); err != nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(remote, ext), i, ext)
+	}
+}
+
+func (l *Local) metadata(remote string) (dropbox.FileMetadata, error) {
+	info, err := os.Stat(l.localPath(remote))
+	if err != nil {
+		return dropbox.FileMetadata{}, err
+	}
+	return dropbox.FileMetadata{
+		Name:           path.Base(remote),
+		PathLower:      remote,
+		ClientModified: info.ModTime().UTC(),
+		ServerModified: info.ModTime().UTC(),
+		Size:           info.Size(),
+	}, nil
+}
+
+func sameContent(a, b string) (bool, error) {
+	hashA, err := dropbox.FileContentHash(a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := dropbox.FileContentHash(b)
+	if err != nil {
+		return false, err
+	}
+	return hashA == hashB, nil
+}
+
+func (l *Local) localPath(path string) string {
+	return filepath.Join(l.root, filepath.FromSlash(strings.TrimPrefix(path, "/")))
+}
+
+// copyFile writes through a temporary file in the target directory so a
+// failed copy never leaves a truncated file behind.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.part")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"radiobuenavia/internal/dropbox"
+)
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocalListFilesToProcessSkipsArchived(t *testing.T) {
+	root := t.TempDir()
+	stamp := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(root, "in", "done.mp3"), "a", stamp)
+	writeFile(t, filepath.Join(root, "in", "new.mp3"), "b", stamp)
+	done := dropbox.RenameFile(dropbox.FileMetadata{Name: "done.mp3", ClientModified: stamp})
+	writeFile(t, filepath.Join(root, "archive", done), "a", time.Time{})
+
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	files, err := store.ListFilesToProcessContext(ctx, []string{"/in"}, "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "new.mp3" {
+		t.Fatalf("expected only new.mp3, got %+v", files)
+	}
+	if files[0].PathLower != "/in/new.mp3" {
+		t.Fatalf("expected remote-style path, got %q", files[0].PathLower)
+	}
+}
+
+func TestLocalPendingFilesResolvesNamesAgainstTheArchive(t *testing.T) {
+	root := t.TempDir()
+	stamp := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	first := dropbox.RenameFile(dropbox.FileMetadata{Name: "mix.mp3", ClientModified: stamp})
+	writeFile(t, filepath.Join(root, "archive", first), "first", stamp.Add(time.Hour))
+	writeFile(t, filepath.Join(root, "in", "mix.mp3"), "second", stamp.Add(2*time.Hour))
+
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := store.ListPendingFilesContext(context.Background(), []string{"/in", "/in"}, "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected the second mix once, got %+v", files)
+	}
+	if got := dropbox.RenameFile(files[0]); got != "mix - Radio Buena Vida 09.03.24 (2).mp3" {
+		t.Fatalf("expected the second mix to get a suffix, got %q", got)
+	}
+}
+
+func TestLocalUploadThenArchive(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(t.TempDir(), "ex.mp3")
+	writeFile(t, src, "audio", time.Time{})
+
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.UploadFileSoundcloudContext(ctx, src, "show.mp3", "/post", dropbox.PolicySkipIfIdentical); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CopyToArchiveContext(ctx, "show.mp3", "/post", "/archive", dropbox.PolicySkipIfIdentical); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "archive", "show.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "audio" {
+		t.Fatalf("unexpected archive content %q", got)
+	}
+
+	if _, err := store.UploadFileSoundcloudContext(ctx, src, "show.mp3", "/post", dropbox.PolicySkipIfIdentical); err != nil {
+		t.Fatalf("expected identical re-upload to be skipped, got %v", err)
+	}
+	writeFile(t, src, "remastered", time.Time{})
+	_, err = store.UploadFileSoundcloudContext(ctx, src, "show.mp3", "/post", dropbox.PolicySkipIfIdentical)
+	if !errors.Is(err, dropbox.ErrConflict) || !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected conflict for different content, got %v", err)
+	}
+	renamed, err := store.UploadFileSoundcloudContext(ctx, src, "show.mp3", "/post", dropbox.PolicyAutorename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "show (1).mp3" {
+		t.Fatalf("expected autorenamed upload, got %q", renamed.Name)
+	}
+}
+
+func TestLocalPendingFilesAndMoveToProcessed(t *testing.T) {
+	root := t.TempDir()
+	stamp := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(root, "in", "show.mp3"), "new", stamp)
+	writeFile(t, filepath.Join(root, "in", "legacy.mp3"), "old", stamp)
+	legacy := dropbox.RenameFile(dropbox.FileMetadata{Name: "legacy.mp3", ClientModified: stamp})
+	writeFile(t, filepath.Join(root, "archive", legacy), "old", time.Time{})
+	writeFile(t, filepath.Join(root, "done", "show.mp3"), "earlier", time.Time{})
+
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	files, err := store.ListPendingFilesContext(ctx, []string{"/in"}, "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "show.mp3" {
+		t.Fatalf("expected only show.mp3 pending, got %+v", files)
+	}
+
+	moved, err := store.MoveToProcessedContext(ctx, files[0], "/done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.PathLower != "/done/show (1).mp3" {
+		t.Fatalf("expected autorenamed move, got %q", moved.PathLower)
+	}
+	if _, err := os.Stat(filepath.Join(root, "in", "show.mp3")); !os.IsNotExist(err) {
+		t.Fatalf("expected source to leave the preprocess folder, got %v", err)
+	}
+}
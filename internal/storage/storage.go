@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"radiobuenavia/internal/config"
+	"radiobuenavia/internal/dropbox"
+)
+
+// Backend is the remote side of the pipeline: where sources are listed and
+// downloaded from, and where processed files are uploaded and archived.
+// Paths are slash-separated and rooted at the backend root, as in Dropbox.
+type Backend interface {
+	ListFilesContext(ctx context.Context, path string) ([]dropbox.FileMetadata, error)
+	GetMetadataContext(ctx context.Context, path string) (dropbox.FileMetadata, error)
+	ListFilesToProcessContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]dropbox.FileMetadata, error)
+	ListPendingFilesContext(ctx context.Context, preprocessPaths []string, archivePath string) ([]dropbox.FileMetadata, error)
+	DownloadFileContext(ctx context.Context, localPath string, file dropbox.FileMetadata) error
+	UploadFileSoundcloudContext(ctx context.Context, localPath, name, soundcloudPath string, policy dropbox.WritePolicy) (dropbox.FileMetadata, error)
+	CopyToArchiveContext(ctx context.Context, name, soundcloudPath, archivePath string, policy dropbox.WritePolicy) (dropbox.FileMetadata, error)
+	CopyBatchToArchiveContext(ctx context.Context, names []string, soundcloudPath, archivePath string, policy dropbox.WritePolicy) ([]dropbox.CopyResult, error)
+	MoveToProcessedContext(ctx context.Context, file dropbox.FileMetadata, processedPath string) (dropbox.FileMetadata, error)
+}
+
+// Watcher is implemented by backends that can report folder changes, which
+// lets `rbv watch` wait for new uploads instead of polling.
+type Watcher interface {
+	LatestCursorContext(ctx context.Context, path string) (string, error)
+	ListChangesContext(ctx context.Context, cursor string) ([]dropbox.FileMetadata, string, error)
+	LongpollContext(ctx context.Context, cursor string, timeout time.Duration) (bool, time.Duration, error)
+}
+
+// Sharer is implemented by backends that can hand out links to published
+// files.
+type Sharer interface {
+	CreateSharedLinkContext(ctx context.Context, path string, settings dropbox.SharedLinkSettings) (string, error)
+}
+
+// Marker is implemented by backends that can record on a source file that
+// it has been processed.
+type Marker interface {
+	MarkProcessedContext(ctx context.Context, path string, state dropbox.ProcessedState) error
+}
+
+var (
+	_ Backend = (*dropbox.Client)(nil)
+	_ Backend = (*Local)(nil)
+	_ Watcher = (*dropbox.Client)(nil)
+	_ Sharer  = (*dropbox.Client)(nil)
+	_ Marker  = (*dropbox.Client)(nil)
+)
+
+func New(cfg config.Config) (Backend, error) {
+	return open(cfg, false)
+}
+
+// NewReadOnly is New for callers that only read, such as the dry-run plan:
+// it looks up the processed-state template but never adds it.
+func NewReadOnly(cfg config.Config) (Backend, error) {
+	return open(cfg, true)
+}
+
+func open(cfg config.Config, readOnly bool) (Backend, error) {
+	switch cfg.Storage.Backend {
+	case "", config.BackendDropbox:
+		// Load has already validated the timeouts.
+		requestTimeout, _ := time.ParseDuration(cfg.Timeouts.Request)
+		transferTimeout, _ := time.ParseDuration(cfg.Timeouts.Transfer)
+		dbx, err := dropbox.NewClient(cfg.Auth.AppKey, cfg.Auth.AppSecret, cfg.Auth.RefreshToken,
+			dropbox.WithTimeouts(requestTimeout, transferTimeout),
+			dropbox.WithRateLimits(dropbox.RateLimits{
+				RequestsPerSecond:      cfg.Limits.RequestsPerSecond,
+				UploadBytesPerSecond:   bytesPerSecond(cfg.Limits.UploadMbps),
+				DownloadBytesPerSecond: bytesPerSecond(cfg.Limits.DownloadMbps),
+			}))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Storage.TeamSpace {
+			if _, err := dbx.UseTeamSpace(); err != nil {
+				return nil, fmt.Errorf("team space: %w", err)
+			}
+		}
+		if cfg.State.Properties {
+			useTemplate := dbx.UseProcessedTemplateContext
+			if readOnly {
+				useTemplate = dbx.FindProcessedTemplateContext
+			}
+			if _, err := useTemplate(context.Background()); err != nil {
+				return nil, fmt.Errorf("processed-state template: %w", err)
+			}
+		}
+		return dbx, nil
+	case config.BackendLocal:
+		return NewLocal(cfg.Storage.LocalRoot)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+func bytesPerSecond(mbps float64) int64 {
+	return int64(mbps * 1e6 / 8)
+}
+
+func remotePath(base, name string) string {
+	if strings.HasSuffix(base, "/") {
+		return base + name
+	}
+	return base + "/" + name
+}